@@ -3,3 +3,5 @@ module github.com/hseinmoussa/shopify-webhook-go/adapters/chi
 go 1.24.2
 
 require github.com/hseinmoussa/shopify-webhook-go v0.2.0
+
+replace github.com/hseinmoussa/shopify-webhook-go => ../..
@@ -3,6 +3,7 @@ package echo
 
 import (
 	"bytes"
+	"errors"
 	"io"
 
 	"github.com/labstack/echo/v4"
@@ -12,11 +13,19 @@ import (
 const contextKey = "shopify_event"
 
 // Middleware returns Echo middleware that verifies Shopify webhooks
-// and stores the Event in the Echo context.
-func Middleware(secret string) echo.MiddlewareFunc {
+// and stores the Event in the Echo context. Options such as
+// shopifywebhook.WithMaxBodySize and shopifywebhook.WithEarlyHeaderCheck
+// configure how the request is read.
+func Middleware(secret string, opts ...shopifywebhook.VerifyOption) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			body, err := shopifywebhook.VerifyRequest(secret, c.Request())
+			body, err := shopifywebhook.VerifyRequest(secret, c.Request(), opts...)
+			if errors.Is(err, shopifywebhook.ErrBodyTooLarge) {
+				return c.NoContent(413)
+			}
+			if errors.Is(err, shopifywebhook.ErrMissingTopic) {
+				return c.NoContent(400)
+			}
 			if err != nil {
 				return c.NoContent(401)
 			}
@@ -18,3 +18,5 @@ require (
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 )
+
+replace github.com/hseinmoussa/shopify-webhook-go => ../..
@@ -3,6 +3,7 @@ package gin
 
 import (
 	"bytes"
+	"errors"
 	"io"
 
 	"github.com/gin-gonic/gin"
@@ -12,10 +13,20 @@ import (
 const contextKey = "shopify_event"
 
 // Middleware returns Gin middleware that verifies Shopify webhooks
-// and stores the Event in the Gin context.
-func Middleware(secret string) gin.HandlerFunc {
+// and stores the Event in the Gin context. Options such as
+// shopifywebhook.WithMaxBodySize and shopifywebhook.WithEarlyHeaderCheck
+// configure how the request is read.
+func Middleware(secret string, opts ...shopifywebhook.VerifyOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		body, err := shopifywebhook.VerifyRequest(secret, c.Request)
+		body, err := shopifywebhook.VerifyRequest(secret, c.Request, opts...)
+		if errors.Is(err, shopifywebhook.ErrBodyTooLarge) {
+			c.AbortWithStatus(413)
+			return
+		}
+		if errors.Is(err, shopifywebhook.ErrMissingTopic) {
+			c.AbortWithStatus(400)
+			return
+		}
 		if err != nil {
 			c.AbortWithStatus(401)
 			return
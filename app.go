@@ -0,0 +1,101 @@
+package shopifywebhook
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// App describes one Shopify app served by a shared webhook endpoint.
+type App struct {
+	// Secrets verifies webhooks for the app. Required.
+	Secrets SecretProvider
+
+	// Router receives the app's events. If nil, the Router passed to
+	// MultiAppHandler is used.
+	Router *Router
+}
+
+// AppResolver picks the App that a webhook request belongs to.
+//
+// Resolution runs before the signature is verified, so it may only use
+// request data such as the path or headers. That is safe: a request that
+// claims to belong to an app still has to be signed with that app's secret.
+type AppResolver interface {
+	ResolveApp(r *http.Request) (App, error)
+}
+
+// AppResolverFunc adapts a function to the AppResolver interface.
+type AppResolverFunc func(r *http.Request) (App, error)
+
+// ResolveApp calls f(r).
+func (f AppResolverFunc) ResolveApp(r *http.Request) (App, error) {
+	return f(r)
+}
+
+// ResolveByPath resolves apps by exact request path, for setups where each
+// app's webhook subscriptions point at a different URL:
+//
+//	shopifywebhook.ResolveByPath(map[string]shopifywebhook.App{
+//	    "/webhooks/reviews": {Secrets: reviewsSecrets, Router: reviewsRouter},
+//	    "/webhooks/loyalty": {Secrets: loyaltySecrets, Router: loyaltyRouter},
+//	})
+func ResolveByPath(apps map[string]App) AppResolver {
+	return AppResolverFunc(func(r *http.Request) (App, error) {
+		return lookupApp(apps, "path", r.URL.Path)
+	})
+}
+
+// ResolveByShopDomain resolves apps by the X-Shopify-Shop-Domain header,
+// for custom apps that are installed on a single shop each.
+func ResolveByShopDomain(apps map[string]App) AppResolver {
+	return ResolveByHeader("X-Shopify-Shop-Domain", apps)
+}
+
+// ResolveByHeader resolves apps by the value of a request header, such as
+// one added by an ingress or API gateway.
+func ResolveByHeader(header string, apps map[string]App) AppResolver {
+	return AppResolverFunc(func(r *http.Request) (App, error) {
+		return lookupApp(apps, header, r.Header.Get(header))
+	})
+}
+
+func lookupApp(apps map[string]App, source, key string) (App, error) {
+	app, ok := apps[key]
+	if !ok {
+		return App{}, fmt.Errorf("%w: %s %q", ErrUnknownApp, source, key)
+	}
+	return app, nil
+}
+
+// MultiAppHandler returns an http.Handler that serves webhooks for several
+// Shopify apps from one endpoint. For each request, resolver picks the app
+// whose secrets verify the signature and whose Router (or router, if the
+// app has none) receives the event.
+//
+// Requests that cannot be resolved are rejected through the verify error
+// handler (401 Unauthorized by default) with an error wrapping ErrUnknownApp.
+// All other behaviour, including options, matches Handler.
+//
+// When apps share a Router, give each app's secrets distinct IDs so
+// handlers can tell them apart via Metadata.SecretID.
+func MultiAppHandler(resolver AppResolver, router *Router, opts ...HandlerOption) http.Handler {
+	cfg := newHandlerConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app, err := resolver.ResolveApp(r)
+		if err != nil {
+			cfg.onVerifyError(w, r, err)
+			return
+		}
+
+		appRouter := app.Router
+		if appRouter == nil {
+			appRouter = router
+		}
+		if app.Secrets == nil || appRouter == nil {
+			cfg.onVerifyError(w, r, fmt.Errorf("%w: incomplete app configuration", ErrUnknownApp))
+			return
+		}
+
+		cfg.serve(w, r, app.Secrets, appRouter)
+	})
+}
@@ -0,0 +1,105 @@
+package shopifywebhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiAppHandler_ByShopDomain(t *testing.T) {
+	var reviewsCalled, loyaltyCalled bool
+
+	reviews := NewRouter()
+	reviews.Handle(TopicOrdersCreate, func(event Event) error {
+		reviewsCalled = true
+		return nil
+	})
+	loyalty := NewRouter()
+	loyalty.Handle(TopicOrdersCreate, func(event Event) error {
+		loyaltyCalled = true
+		return nil
+	})
+
+	handler := MultiAppHandler(ResolveByShopDomain(map[string]App{
+		"test.myshopify.com":  {Secrets: StaticSecret("reviews-secret"), Router: reviews},
+		"other.myshopify.com": {Secrets: StaticSecret("loyalty-secret"), Router: loyalty},
+	}), nil)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("reviews-secret", `{"id":1}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !reviewsCalled || loyaltyCalled {
+		t.Fatalf("expected only reviews router, got reviews=%v loyalty=%v", reviewsCalled, loyaltyCalled)
+	}
+}
+
+func TestMultiAppHandler_ByPath_DefaultRouter(t *testing.T) {
+	var secretID string
+	shared := NewRouter()
+	shared.Handle(TopicOrdersCreate, func(event Event) error {
+		secretID = event.Metadata.SecretID
+		return nil
+	})
+
+	handler := MultiAppHandler(ResolveByPath(map[string]App{
+		"/webhooks": {Secrets: NewSecretSet(Secret{ID: "app-a", Value: "secret-a"})},
+		"/other":    {Secrets: NewSecretSet(Secret{ID: "app-b", Value: "secret-b"})},
+	}), shared)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("secret-a", `{"id":1}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if secretID != "app-a" {
+		t.Fatalf("expected SecretID %q, got %q", "app-a", secretID)
+	}
+}
+
+func TestMultiAppHandler_WrongAppSecret(t *testing.T) {
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		t.Fatal("handler should not be called")
+		return nil
+	})
+
+	handler := MultiAppHandler(ResolveByHeader("X-App", map[string]App{
+		"reviews": {Secrets: StaticSecret("reviews-secret")},
+		"loyalty": {Secrets: StaticSecret("loyalty-secret")},
+	}), router)
+
+	// Signed by the loyalty app but claims to be the reviews app.
+	req := signedRequest("loyalty-secret", `{"id":1}`, TopicOrdersCreate)
+	req.Header.Set("X-App", "reviews")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestMultiAppHandler_UnknownApp(t *testing.T) {
+	var gotErr error
+	handler := MultiAppHandler(ResolveByShopDomain(map[string]App{}), NewRouter(),
+		WithHandlerVerifyErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("secret", `{}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 from custom handler, got %d", rr.Code)
+	}
+	if !errors.Is(gotErr, ErrUnknownApp) {
+		t.Fatalf("expected ErrUnknownApp, got: %v", gotErr)
+	}
+}
@@ -2,47 +2,103 @@ package shopifywebhook
 
 import (
 	"context"
-	"math"
+	"fmt"
+	"runtime/debug"
+	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 // AsyncProcessor submits events for background processing.
 // Implement this interface to use a custom queue (e.g., SQS, Kafka, Redis).
 type AsyncProcessor interface {
-	// Submit enqueues an event for processing. Must not block.
-	Submit(event Event, router *Router)
+	// Submit enqueues an event for processing. Handler calls it before
+	// responding to Shopify, so it must return quickly; it may block
+	// briefly to persist the event, as DurableQueue does.
+	//
+	// It returns an error if the event was not accepted, e.g. ErrQueueFull
+	// when the processor is overloaded. The event is then the caller's
+	// responsibility: Handler answers 503 so that Shopify redelivers it.
+	Submit(event Event, router *Router) error
 
 	// Shutdown gracefully waits for pending events to complete.
 	// The context can set a deadline for the shutdown.
 	Shutdown(ctx context.Context) error
 }
 
+// CompletionFunc receives the final outcome of an event submitted for
+// background processing: nil once it was dispatched successfully, or the
+// last error once retries are exhausted or abandoned.
+type CompletionFunc func(event Event, err error)
+
+// TrackingProcessor is an AsyncProcessor that can report each event's final
+// outcome. Handler uses it to mark events as processed in the idempotency
+// store only after they succeed, so failed events stay eligible for
+// Shopify's redelivery. WorkerPool implements it.
+type TrackingProcessor interface {
+	AsyncProcessor
+
+	// SubmitTracked is like Submit, but calls done exactly once with the
+	// final outcome of an accepted event. done is not called if the event
+	// is rejected, and may be nil.
+	SubmitTracked(event Event, router *Router, done CompletionFunc) error
+}
+
 // WorkerPool is a channel-based AsyncProcessor with a fixed number of workers.
 //
 // By default, failed events are reported to the error handler and discarded.
-// Use WithMaxRetries to enable automatic retries with exponential backoff.
+// Use WithMaxRetries to enable automatic retries with exponential backoff,
+// or WithRetryPolicy and WithTopicRetryPolicy for finer control.
+//
+// Retries don't hold a worker while they back off: a failed event is put on
+// a delay queue and re-enqueued when its retry is due, so healthy events
+// keep flowing in the meantime.
+//
+// With WithPartitionKey, events are partitioned by key, and each key's
+// events are processed one at a time, in order, while keys take turns for
+// the workers.
+//
+// Handlers receive a context that is detached from the HTTP request but
+// cancelled if Shutdown's context expires before the queue is drained.
 type WorkerPool struct {
-	queue      chan work
-	wg         sync.WaitGroup
-	onError    ErrorHandlerFunc
-	maxRetries int
-	baseDelay  time.Duration
-	closing    atomic.Bool
+	queue          chan work   // new events, unless partitioned, and due retries
+	keyed          *keyedQueue // new events, if partitioned
+	retries        *delayQueue
+	wg             sync.WaitGroup
+	onError        ErrorHandlerFunc
+	deadLetters    DeadLetterSink
+	policy         RetryPolicy
+	topicPolicies  map[Topic]RetryPolicy
+	policyPatterns []Topic // patterns in topicPolicies, in precedence order
+	ctx            context.Context
+	cancel         context.CancelFunc
+	stop           chan struct{} // closed once workers should exit
+
+	mu       sync.Mutex
+	closing  bool
+	inflight int           // accepted events not yet completed, including scheduled retries
+	drained  chan struct{} // closed once closing and inflight reaches 0
 }
 
 type work struct {
 	event   Event
 	router  *Router
-	attempt int
+	attempt int           // attempts made so far
+	delay   time.Duration // backoff before the current attempt
+	lastErr error         // error from the previous attempt, if any
+	history []DeadLetterAttempt
+	done    CompletionFunc
+	part    *partition // set if the pool is partitioned
 }
 
 // NewWorkerPool creates a pool with the specified number of workers and queue capacity.
 //
-// If the queue is full when Submit is called, the event is dropped and
-// onError is called with ErrQueueFull. This is intentional: blocking the
-// HTTP goroutine would cause Shopify to time out and retry anyway.
+// If the queue is full when Submit is called, the event is rejected with
+// ErrQueueFull, which is also passed to onError. This is intentional:
+// blocking the HTTP goroutine would cause Shopify to time out and retry
+// anyway, so Handler answers 503 and lets Shopify's retry act as the
+// buffer. Retries that come due while the queue is full wait for room
+// instead.
 //
 // Typical production values: workers=10, queueSize=1000.
 func NewWorkerPool(workers, queueSize int, opts ...WorkerPoolOption) *WorkerPool {
@@ -53,99 +109,333 @@ func NewWorkerPool(workers, queueSize int, opts ...WorkerPoolOption) *WorkerPool
 		opt(cfg)
 	}
 
+	policy := cfg.policy
+	if policy == nil {
+		policy = ExponentialBackoff{MaxRetries: cfg.maxRetries, BaseDelay: cfg.baseDelay}
+	}
+	var patterns []Topic
+	for topic := range cfg.topicPolicies {
+		if topic.IsPattern() {
+			validatePattern(topic)
+			patterns = append(patterns, topic)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		return morePrecise(patterns[i], patterns[j])
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
 	wp := &WorkerPool{
-		queue:      make(chan work, queueSize),
-		onError:    cfg.onError,
-		maxRetries: cfg.maxRetries,
-		baseDelay:  cfg.baseDelay,
+		queue:          make(chan work, queueSize),
+		retries:        newDelayQueue(),
+		onError:        cfg.onError,
+		deadLetters:    cfg.deadLetters,
+		policy:         policy,
+		topicPolicies:  cfg.topicPolicies,
+		policyPatterns: patterns,
+		ctx:            ctx,
+		cancel:         cancel,
+		stop:           make(chan struct{}),
+		drained:        make(chan struct{}),
+	}
+	if cfg.partitionKey != nil {
+		wp.keyed = newKeyedQueue(cfg.partitionKey, queueSize)
 	}
 
 	wp.wg.Add(workers)
 	for range workers {
 		go wp.worker()
 	}
+	go wp.retries.run(ctx, wp.queue, wp.abandon)
 
 	return wp
 }
 
 func (wp *WorkerPool) worker() {
 	defer wp.wg.Done()
-	for w := range wp.queue {
-		wp.processWithRetry(w)
+	for {
+		var ready <-chan struct{}
+		if wp.keyed != nil {
+			// Retries first: their partitions can't move on without them.
+			select {
+			case w := <-wp.queue:
+				wp.process(w)
+				continue
+			default:
+			}
+			w, ok, changed := wp.keyed.pop()
+			if ok {
+				wp.process(w)
+				continue
+			}
+			ready = changed
+		}
+
+		select {
+		case w := <-wp.queue:
+			wp.process(w)
+		case <-ready:
+		case <-wp.stop:
+			wp.drain()
+			return
+		}
 	}
 }
 
-func (wp *WorkerPool) processWithRetry(w work) {
-	for attempt := range wp.maxRetries + 1 {
-		err := w.router.Dispatch(w.event)
-		if err == nil {
+// drain runs whatever is left once the workers are stopped. It was accepted
+// before Shutdown timed out, so it runs with the cancelled context to still
+// be reported.
+func (wp *WorkerPool) drain() {
+	for {
+		select {
+		case w := <-wp.queue:
+			wp.process(w)
+			continue
+		default:
+		}
+		if wp.keyed == nil {
 			return
 		}
-
-		if attempt < wp.maxRetries {
-			// Exponential backoff: 500ms, 1s, 2s, 4s, ...
-			delay := wp.baseDelay * time.Duration(math.Pow(2, float64(attempt)))
-			time.Sleep(delay)
+		w, ok, changed := wp.keyed.pop()
+		if ok {
+			wp.process(w)
 			continue
 		}
+		if wp.keyed.len() == 0 {
+			return
+		}
+		// The remaining partitions are running on other workers.
+		<-changed
+	}
+}
+
+// process makes one attempt at w. On failure, it schedules a retry if the
+// retry policy allows one, and otherwise reports the error.
+func (wp *WorkerPool) process(w work) {
+	err := wp.dispatch(w)
+	if err == nil {
+		wp.finish(w, nil)
+		return
+	}
+	w.attempt++
+	w.lastErr = err
+	if wp.deadLetters != nil {
+		w.history = append(w.history, DeadLetterAttempt{Time: time.Now(), Error: err.Error()})
+	}
+
+	if wp.ctx.Err() == nil {
+		delay, retry := wp.retryPolicy(w.event.Metadata.Topic).NextRetry(w.attempt, w.delay, err)
+		if retry {
+			w.delay = delay
+			if wp.retries.schedule(w, time.Now().Add(delay)) {
+				return
+			}
+		}
+	}
+
+	// The policy gave up (or no retries are configured), or the pool
+	// was cancelled during shutdown.
+	wp.fail(w, err)
+}
+
+// abandon reports a scheduled retry that will not run because the pool
+// was cancelled.
+func (wp *WorkerPool) abandon(w work) {
+	wp.fail(w, w.lastErr)
+}
+
+func (wp *WorkerPool) fail(w work, err error) {
+	wp.report(w, err)
+	wp.finish(w, err)
+}
+
+// report passes a failed event to the error handler and the dead-letter
+// sink, if configured.
+func (wp *WorkerPool) report(w work, err error) {
+	if wp.onError != nil {
+		wp.onError(w.event, err)
+	}
+	if wp.deadLetters == nil {
+		return
+	}
+	letter := DeadLetter{
+		Event:             w.event,
+		Error:             err.Error(),
+		FailedSubscribers: failedSubscribers(err),
+		Attempts:          w.history,
+		Time:              time.Now(),
+	}
+	if putErr := wp.deadLetters.Put(context.Background(), letter); putErr != nil && wp.onError != nil {
+		wp.onError(w.event, fmt.Errorf("shopifywebhook: writing dead letter: %w", putErr))
+	}
+}
+
+// finish reports w's final outcome and stops tracking it.
+func (wp *WorkerPool) finish(w work, err error) {
+	w.complete(err)
+	if w.part != nil {
+		wp.keyed.release(w.part)
+	}
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.inflight--
+	if wp.closing && wp.inflight == 0 {
+		close(wp.drained)
+	}
+}
+
+// retryPolicy returns the policy for topic: its own, that of the most
+// specific matching pattern, or the pool's default.
+func (wp *WorkerPool) retryPolicy(topic Topic) RetryPolicy {
+	if p, ok := wp.topicPolicies[topic]; ok {
+		return p
+	}
+	for _, pattern := range wp.policyPatterns {
+		if pattern.Match(topic) {
+			return wp.topicPolicies[pattern]
+		}
+	}
+	return wp.policy
+}
+
+func (w work) complete(err error) {
+	if w.done != nil {
+		w.done(w.event, err)
+	}
+}
 
-		// Max retries exhausted (or no retries configured).
-		if wp.onError != nil {
-			wp.onError(w.event, err)
+// dispatch routes the event through its router. Retries only re-run the
+// fan-out subscribers that failed on the previous attempt.
+//
+// Handler panics are already recovered by the Router; this also guards
+// against panics in the router's error handler so a single event can never
+// take down a worker.
+func (wp *WorkerPool) dispatch(w work) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack(), Event: w.event}
 		}
+	}()
+	if w.lastErr != nil {
+		return w.router.RetryFailed(wp.ctx, w.event, w.lastErr)
 	}
+	return w.router.DispatchContext(wp.ctx, w.event)
 }
 
 // Submit enqueues an event for background processing.
-// Non-blocking: drops the event if the queue is full.
-func (wp *WorkerPool) Submit(event Event, router *Router) {
+// Non-blocking: rejects the event with ErrQueueFull if the queue is full,
+// or ErrPoolClosed if Shutdown has been called.
+func (wp *WorkerPool) Submit(event Event, router *Router) error {
+	return wp.SubmitTracked(event, router, nil)
+}
+
+// SubmitTracked enqueues an event like Submit and calls done with its final
+// outcome: nil on success, or the last error once retries are exhausted.
+// Rejected events are reported to the error handler, but not to done or the
+// dead-letter sink, as the caller gets them back.
+func (wp *WorkerPool) SubmitTracked(event Event, router *Router, done CompletionFunc) error {
+	w := work{event: event, router: router, done: done}
+
+	wp.mu.Lock()
+	err := ErrPoolClosed
+	if !wp.closing {
+		err = ErrQueueFull
+		if wp.enqueue(w) {
+			wp.inflight++
+			err = nil
+		}
+	}
+	wp.mu.Unlock()
+
+	if err != nil && wp.onError != nil {
+		wp.onError(event, err)
+	}
+	return err
+}
+
+// enqueue queues a new event without blocking. It returns false if the
+// queue is full.
+func (wp *WorkerPool) enqueue(w work) bool {
+	if wp.keyed != nil {
+		return wp.keyed.push(w)
+	}
 	select {
-	case wp.queue <- work{event: event, router: router}:
+	case wp.queue <- w:
+		return true
 	default:
-		if wp.onError != nil {
-			wp.onError(event, ErrQueueFull)
-		}
+		return false
 	}
 }
 
-// Shutdown closes the queue and waits for all workers to finish processing.
-// Respects the context deadline.
+// Shutdown stops accepting events and waits until every accepted event,
+// including those waiting for a retry, has completed.
+// Respects the context deadline: if ctx expires first, the context passed
+// to in-flight handlers is cancelled and pending retries are abandoned and
+// reported to the error handler.
 func (wp *WorkerPool) Shutdown(ctx context.Context) error {
-	wp.closing.Store(true)
-	close(wp.queue)
-	done := make(chan struct{})
-	go func() {
-		wp.wg.Wait()
-		close(done)
-	}()
+	wp.mu.Lock()
+	if !wp.closing {
+		wp.closing = true
+		if wp.inflight == 0 {
+			close(wp.drained)
+		}
+	}
+	wp.mu.Unlock()
+
+	var err error
 	select {
-	case <-done:
-		return nil
+	case <-wp.drained:
 	case <-ctx.Done():
-		return ctx.Err()
+		err = ctx.Err()
+	}
+
+	// Stop the delay queue before the workers, so that any retry it hands
+	// over is still picked up by a worker draining the queue.
+	wp.cancel()
+	<-wp.retries.exited
+	close(wp.stop)
+	if err == nil {
+		wp.wg.Wait()
 	}
+	return err
 }
 
 // WorkerPoolOption configures a WorkerPool.
 type WorkerPoolOption func(*workerPoolConfig)
 
 type workerPoolConfig struct {
-	onError    ErrorHandlerFunc
-	maxRetries int
-	baseDelay  time.Duration
+	onError       ErrorHandlerFunc
+	deadLetters   DeadLetterSink
+	maxRetries    int
+	baseDelay     time.Duration
+	policy        RetryPolicy
+	topicPolicies map[Topic]RetryPolicy
+	partitionKey  PartitionKeyFunc
 }
 
 // WithPoolErrorHandler sets the error handler for processing errors
-// and queue-full events.
+// and rejected events.
 func WithPoolErrorHandler(fn ErrorHandlerFunc) WorkerPoolOption {
 	return func(c *workerPoolConfig) {
 		c.onError = fn
 	}
 }
 
+// WithDeadLetterSink records events the pool gives up on — after retries
+// are exhausted, or abandoned when Shutdown times out — in sink, in
+// addition to reporting them to the error handler. If writing to the sink
+// fails, that error is reported to the error handler too. Events rejected
+// by Submit are returned to the caller instead.
+func WithDeadLetterSink(sink DeadLetterSink) WorkerPoolOption {
+	return func(c *workerPoolConfig) {
+		c.deadLetters = sink
+	}
+}
+
 // WithMaxRetries enables automatic retries with exponential backoff.
 // Failed events are re-enqueued up to maxRetries times before being
-// reported to the error handler and discarded.
+// reported to the error handler and discarded. Errors that are not
+// retryable (see IsRetryable) are reported straight away.
 //
 // Backoff schedule (with default 500ms base delay):
 //
@@ -165,3 +455,45 @@ func WithRetryBaseDelay(d time.Duration) WorkerPoolOption {
 		c.baseDelay = d
 	}
 }
+
+// WithRetryPolicy sets the pool's RetryPolicy, replacing the exponential
+// backoff configured by WithMaxRetries and WithRetryBaseDelay:
+//
+//	shopifywebhook.WithRetryPolicy(shopifywebhook.ExponentialBackoff{
+//	    MaxRetries: 5,
+//	    BaseDelay:  time.Second,
+//	    MaxDelay:   time.Minute,
+//	    Jitter:     shopifywebhook.FullJitter,
+//	})
+func WithRetryPolicy(p RetryPolicy) WorkerPoolOption {
+	return func(c *workerPoolConfig) {
+		c.policy = p
+	}
+}
+
+// WithTopicRetryPolicy sets the RetryPolicy for events of a single topic,
+// overriding the pool's. topic may be a pattern, in which case it applies
+// to matching topics without a policy of their own; the most specific
+// pattern wins, as in Router. Panics if a pattern uses "*" other than as a
+// whole segment.
+func WithTopicRetryPolicy(topic Topic, p RetryPolicy) WorkerPoolOption {
+	return func(c *workerPoolConfig) {
+		if c.topicPolicies == nil {
+			c.topicPolicies = make(map[Topic]RetryPolicy)
+		}
+		c.topicPolicies[topic] = p
+	}
+}
+
+// WithPartitionKey partitions events by the key fn derives, e.g.
+// ShopPartitionKey. Events with the same key are processed one at a time,
+// in submission order; an event waiting for a retry holds back the later
+// events with its key, so they can't overtake it. Keys with events waiting
+// take turns for the workers, so one key's backlog can't starve the others.
+//
+// The queue capacity counts waiting events across all keys.
+func WithPartitionKey(fn PartitionKeyFunc) WorkerPoolOption {
+	return func(c *workerPoolConfig) {
+		c.partitionKey = fn
+	}
+}
@@ -36,7 +36,7 @@ func TestWorkerPool_ProcessesEvents(t *testing.T) {
 }
 
 func TestWorkerPool_QueueFull(t *testing.T) {
-	var dropped atomic.Int32
+	var rejected atomic.Int32
 
 	router := NewRouter()
 	router.Handle(TopicOrdersCreate, func(event Event) error {
@@ -46,7 +46,7 @@ func TestWorkerPool_QueueFull(t *testing.T) {
 
 	pool := NewWorkerPool(1, 1, WithPoolErrorHandler(func(event Event, err error) {
 		if errors.Is(err, ErrQueueFull) {
-			dropped.Add(1)
+			rejected.Add(1)
 		}
 	}))
 
@@ -60,8 +60,8 @@ func TestWorkerPool_QueueFull(t *testing.T) {
 
 	_ = pool.Shutdown(context.Background())
 
-	if got := dropped.Load(); got == 0 {
-		t.Fatal("expected at least one dropped event when queue is full")
+	if got := rejected.Load(); got == 0 {
+		t.Fatal("expected at least one rejected event when queue is full")
 	}
 }
 
@@ -206,3 +206,205 @@ func TestWorkerPool_HandlerErrors(t *testing.T) {
 		t.Fatalf("unexpected error: %v", stored)
 	}
 }
+
+func TestWorkerPool_ShutdownCancelsHandlerContext(t *testing.T) {
+	var handlerErr atomic.Value
+
+	router := NewRouter()
+	router.HandleContext(TopicOrdersCreate, func(ctx context.Context, event Event) error {
+		<-ctx.Done()
+		handlerErr.Store(ctx.Err())
+		return ctx.Err()
+	})
+
+	pool := NewWorkerPool(1, 10)
+	pool.Submit(Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate},
+		RawBody:  []byte(`{}`),
+	}, router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for handlerErr.Load() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := handlerErr.Load(); got != context.Canceled {
+		t.Fatalf("expected handler context to be cancelled, got %v", got)
+	}
+}
+
+func TestWorkerPool_RecoversPanicAndRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var finalErr atomic.Value
+
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		attempts.Add(1)
+		panic("handler exploded")
+	})
+
+	pool := NewWorkerPool(1, 10,
+		WithMaxRetries(2),
+		WithRetryBaseDelay(time.Millisecond),
+		WithPoolErrorHandler(func(event Event, err error) {
+			finalErr.Store(err)
+		}),
+	)
+
+	pool.Submit(Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate},
+		RawBody:  []byte(`{}`),
+	}, router)
+
+	_ = pool.Shutdown(context.Background())
+
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+	var panicErr *PanicError
+	if err, _ := finalErr.Load().(error); !errors.As(err, &panicErr) {
+		t.Fatalf("expected *PanicError, got: %v", finalErr.Load())
+	}
+}
+
+func TestWorkerPool_SubmitTrackedReportsOutcome(t *testing.T) {
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error { return nil })
+	router.Handle(TopicOrdersUpdate, func(event Event) error { return errors.New("boom") })
+
+	pool := NewWorkerPool(1, 10)
+
+	results := make(chan error, 2)
+	done := func(_ Event, err error) { results <- err }
+	pool.SubmitTracked(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, router, done)
+	pool.SubmitTracked(Event{Metadata: Metadata{Topic: TopicOrdersUpdate}}, router, done)
+	_ = pool.Shutdown(context.Background())
+
+	if err := <-results; err != nil {
+		t.Fatalf("expected nil for successful event, got %v", err)
+	}
+	if err := <-results; err == nil {
+		t.Fatal("expected error for failed event")
+	}
+}
+
+func TestWorkerPool_DoesNotRetryPermanentErrors(t *testing.T) {
+	var attempts atomic.Int32
+
+	router := NewRouter()
+	HandleTyped(router, TopicOrdersCreate, func(ctx context.Context, event Event, order Order) error {
+		return nil
+	})
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		attempts.Add(1)
+		return Permanent(errors.New("bad data"))
+	})
+
+	var reported atomic.Int32
+	pool := NewWorkerPool(1, 100,
+		WithMaxRetries(3),
+		WithRetryBaseDelay(time.Millisecond),
+		WithPoolErrorHandler(func(event Event, err error) { reported.Add(1) }),
+	)
+
+	// The orders/create body never decodes into an Order.
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersCreate}, RawBody: []byte(`[]`)}, router)
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersUpdate}, RawBody: []byte(`{}`)}, router)
+	_ = pool.Shutdown(context.Background())
+
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("expected 1 attempt for a permanent error, got %d", got)
+	}
+	if got := reported.Load(); got != 2 {
+		t.Fatalf("expected both events reported, got %d", got)
+	}
+}
+
+func TestWorkerPool_TopicRetryPolicy(t *testing.T) {
+	var creates, updates atomic.Int32
+
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		creates.Add(1)
+		return errors.New("fail")
+	})
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		updates.Add(1)
+		return errors.New("fail")
+	})
+
+	pool := NewWorkerPool(1, 100,
+		WithRetryPolicy(ExponentialBackoff{MaxRetries: 1, BaseDelay: time.Millisecond}),
+		WithTopicRetryPolicy("orders/*", ExponentialBackoff{MaxRetries: 3, BaseDelay: time.Millisecond}),
+	)
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, router)
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicProductsUpdate}}, router)
+	_ = pool.Shutdown(context.Background())
+
+	if got := creates.Load(); got != 4 {
+		t.Fatalf("expected 4 attempts with the topic policy, got %d", got)
+	}
+	if got := updates.Load(); got != 2 {
+		t.Fatalf("expected 2 attempts with the pool policy, got %d", got)
+	}
+}
+
+func TestWorkerPool_RetryBackoffDoesNotBlockWorkers(t *testing.T) {
+	healthy := make(chan struct{})
+
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		return errors.New("fail")
+	})
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		close(healthy)
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 10, WithMaxRetries(1), WithRetryBaseDelay(time.Hour))
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, router)
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersUpdate}}, router)
+
+	select {
+	case <-healthy:
+	case <-time.After(time.Second):
+		t.Fatal("healthy event was blocked behind a backing-off retry")
+	}
+	pool.retries.mu.Lock()
+	scheduled := len(pool.retries.items)
+	pool.retries.mu.Unlock()
+	if scheduled != 1 {
+		t.Fatalf("expected 1 scheduled retry, got %d", scheduled)
+	}
+
+	var abandoned atomic.Value
+	pool.onError = func(event Event, err error) { abandoned.Store(err) }
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if err, _ := abandoned.Load().(error); err == nil || err.Error() != "fail" {
+		t.Fatalf("expected abandoned retry to be reported with its last error, got %v", err)
+	}
+}
+
+func TestWorkerPool_SubmitAfterShutdown(t *testing.T) {
+	pool := NewWorkerPool(1, 10)
+	_ = pool.Shutdown(context.Background())
+
+	called := false
+	err := pool.SubmitTracked(Event{}, NewRouter(), func(Event, error) { called = true })
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+	if called {
+		t.Fatal("expected done not to be called for a rejected event")
+	}
+}
@@ -0,0 +1,257 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Coalescer is an AsyncProcessor that debounces bursts of events for the
+// same resource, such as the flurry of products/update webhooks fired while
+// a product is edited in the admin. It holds each event for a window and
+// passes only the latest one for its key on to another processor.
+//
+// By default, events are keyed on their topic, shop domain and payload
+// "id" (see PayloadKey), so a products/update burst for one product
+// collapses to one event while other topics and products are unaffected.
+// Events submitted with different routers, such as those of the apps of a
+// MultiAppHandler, are never collapsed together. Events without a key are
+// passed on immediately.
+//
+// Each new event for a key restarts its window, but no event is held
+// longer than the max wait after the first one of its burst, so a
+// continuous stream of updates is still processed periodically.
+//
+// A Coalescer is not durable: events it holds are lost if the process
+// crashes before their window closes, even though Shopify has already
+// been answered 200. Wrapping a DurableQueue as the inner processor only
+// persists events once their window closes, so keep windows short.
+type Coalescer struct {
+	inner      TrackingProcessor
+	window     time.Duration
+	maxWait    time.Duration
+	maxPending int
+	keyFn      func(event Event) string
+	onError    ErrorHandlerFunc
+
+	mu       sync.Mutex
+	closing  bool
+	pending  map[coalesceKey]*coalesced
+	flushing sync.WaitGroup     // flushes started by expiring windows
+	ctx      context.Context    // canceled to abandon those flushes
+	cancel   context.CancelFunc // on a Shutdown past its deadline
+}
+
+// coalesceKey identifies the burst an event belongs to.
+type coalesceKey struct {
+	router *Router
+	key    string
+}
+
+// coalesced is the burst of events waiting for one key.
+type coalesced struct {
+	event    Event
+	router   *Router
+	dones    []CompletionFunc // of every event in the burst
+	deadline time.Time        // when the window closes
+	limit    time.Time        // deadline can't move past this
+	timer    *time.Timer
+}
+
+// CoalescerOption configures a Coalescer.
+type CoalescerOption func(*Coalescer)
+
+// WithMaxWait caps how long the first event of a burst can be held while
+// later events keep extending the window. Default: 10 times the window.
+func WithMaxWait(d time.Duration) CoalescerOption {
+	return func(c *Coalescer) {
+		c.maxWait = d
+	}
+}
+
+// WithMaxPending caps how many keys can have an event held at once. Once
+// the cap is reached, Submit rejects events for new keys with ErrQueueFull,
+// so Handler answers 503 as it does for a full WorkerPool. Default: 10000.
+func WithMaxPending(n int) CoalescerOption {
+	return func(c *Coalescer) {
+		c.maxPending = n
+	}
+}
+
+// WithCoalesceKey sets how the key events are coalesced on is derived.
+// Events with the same key are collapsed into the latest one; events for
+// which fn returns an empty key are never held. Default: PayloadKey("id").
+func WithCoalesceKey(fn func(event Event) string) CoalescerOption {
+	return func(c *Coalescer) {
+		c.keyFn = fn
+	}
+}
+
+// WithCoalesceErrorHandler sets the handler for events the inner processor
+// rejects once their window closes, other than with ErrQueueFull, which is
+// retried until the event is accepted. Such events were already accepted,
+// so this is the only place they are reported.
+func WithCoalesceErrorHandler(fn ErrorHandlerFunc) CoalescerOption {
+	return func(c *Coalescer) {
+		c.onError = fn
+	}
+}
+
+// NewCoalescer creates a Coalescer that holds events for window before
+// passing the latest of each burst to inner. A window of zero or less
+// disables coalescing: events are passed on immediately.
+//
+//	pool := shopifywebhook.NewWorkerPool(10, 1000)
+//	coalescer := shopifywebhook.NewCoalescer(pool, 3*time.Second)
+//	handler := shopifywebhook.Handler(secret, router,
+//	    shopifywebhook.WithAsyncProcessor(coalescer))
+//
+// When a window closes while inner is full, its event is resubmitted until
+// inner accepts it, as Shopify has already been answered. Events inner
+// rejects for any other reason, e.g. because it was shut down, are
+// reported to the error handler (see WithCoalesceErrorHandler).
+//
+// The Coalescer owns inner: Shutdown shuts it down.
+func NewCoalescer(inner TrackingProcessor, window time.Duration, opts ...CoalescerOption) *Coalescer {
+	c := &Coalescer{
+		inner:      inner,
+		window:     window,
+		maxWait:    10 * window,
+		maxPending: 10000,
+		keyFn:      PayloadKey("id"),
+		pending:    make(map[coalesceKey]*coalesced),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxWait <= 0 {
+		c.maxWait = c.window
+	}
+	return c
+}
+
+// Submit holds the event until its key's window closes, replacing any
+// event already held for the key. It returns ErrQueueFull if the event's
+// key would exceed the cap on held keys (see WithMaxPending), or
+// ErrPoolClosed once Shutdown has been called.
+func (c *Coalescer) Submit(event Event, router *Router) error {
+	return c.SubmitTracked(event, router, nil)
+}
+
+// SubmitTracked is like Submit, and calls done with the final outcome of
+// the event that is eventually processed in this event's place: itself,
+// or a later event for the same key that superseded it.
+func (c *Coalescer) SubmitTracked(event Event, router *Router, done CompletionFunc) error {
+	k := c.keyFn(event)
+	if k == "" || c.window <= 0 {
+		return c.inner.SubmitTracked(event, router, done)
+	}
+	key := coalesceKey{router: router, key: k}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return ErrPoolClosed
+	}
+
+	b, ok := c.pending[key]
+	if !ok {
+		if len(c.pending) >= c.maxPending {
+			return ErrQueueFull
+		}
+		b = &coalesced{limit: now.Add(c.maxWait)}
+		c.pending[key] = b
+		b.timer = time.AfterFunc(min(c.window, c.maxWait), func() { c.expire(key, b) })
+	}
+	b.event, b.router = event, router
+	if done != nil {
+		b.dones = append(b.dones, done)
+	}
+	// The timer fires at the original deadline and re-arms itself for
+	// the extended one, so it never needs resetting here.
+	b.deadline = now.Add(c.window)
+	if b.deadline.After(b.limit) {
+		b.deadline = b.limit
+	}
+	return nil
+}
+
+// expire flushes b if its window has closed, or re-arms its timer if the
+// window was extended since.
+func (c *Coalescer) expire(key coalesceKey, b *coalesced) {
+	c.mu.Lock()
+	if c.pending[key] != b {
+		c.mu.Unlock()
+		return
+	}
+	if wait := time.Until(b.deadline); wait > 0 {
+		b.timer.Reset(wait)
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, key)
+	c.flushing.Add(1)
+	c.mu.Unlock()
+
+	defer c.flushing.Done()
+	c.flush(c.ctx, b)
+}
+
+// flush hands the latest event of b to the inner processor, waiting for
+// room while it is full until ctx is done.
+func (c *Coalescer) flush(ctx context.Context, b *coalesced) {
+	dones := b.dones
+	for {
+		err := c.inner.SubmitTracked(b.event, b.router, func(event Event, err error) {
+			for _, done := range dones {
+				done(event, err)
+			}
+		})
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrQueueFull) {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		c.reject(b, err)
+		return
+	}
+}
+
+// reject reports that the latest event of b will not be processed.
+func (c *Coalescer) reject(b *coalesced, err error) {
+	if c.onError != nil {
+		c.onError(b.event, err)
+	}
+	for _, done := range b.dones {
+		done(b.event, err)
+	}
+}
+
+// Shutdown stops accepting events, immediately passes on every event still
+// held, and shuts down the inner processor. Events that can't be passed on
+// before ctx is done are reported to the error handler.
+func (c *Coalescer) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closing = true
+	pending := c.pending
+	c.pending = make(map[coalesceKey]*coalesced)
+	c.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, c.cancel)
+	defer stop()
+	for _, b := range pending {
+		b.timer.Stop()
+		c.flush(c.ctx, b)
+	}
+	c.flushing.Wait()
+	return c.inner.Shutdown(ctx)
+}
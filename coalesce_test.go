@@ -0,0 +1,205 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func productEvent(id, title string) Event {
+	return Event{
+		Metadata: Metadata{Topic: TopicProductsUpdate, ShopDomain: "test.myshopify.com"},
+		RawBody:  []byte(fmt.Sprintf(`{"id":%s,"title":%q}`, id, title)),
+	}
+}
+
+// titleRouter records the titles of the products/update events it receives.
+func titleRouter() (*Router, func() []string) {
+	var mu sync.Mutex
+	var titles []string
+	router := NewRouter()
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		var p Product
+		if err := event.Unmarshal(&p); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		titles = append(titles, p.Title)
+		return nil
+	})
+	return router, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), titles...)
+	}
+}
+
+func TestCoalescer_DispatchesLatestOfBurst(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), 30*time.Millisecond)
+
+	var outcomes atomic.Int32
+	for i := range 5 {
+		err := c.SubmitTracked(productEvent("1", fmt.Sprint("v", i)), router, func(_ Event, err error) {
+			if err == nil {
+				outcomes.Add(1)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = c.Submit(productEvent("2", "other"), router)
+
+	waitFor(t, func() bool { return len(titles()) == 2 })
+	_ = c.Shutdown(context.Background())
+
+	got := titles()
+	if len(got) != 2 || (got[0] != "v4" && got[1] != "v4") {
+		t.Fatalf("expected only the latest event per product, got %v", got)
+	}
+	if outcomes.Load() != 5 {
+		t.Fatalf("expected every coalesced event to get the outcome, got %d", outcomes.Load())
+	}
+}
+
+func TestCoalescer_MaxWait(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), 40*time.Millisecond, WithMaxWait(100*time.Millisecond))
+
+	// A steady stream never lets the window close on its own.
+	for i := range 15 {
+		_ = c.Submit(productEvent("1", fmt.Sprint("v", i)), router)
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = c.Shutdown(context.Background())
+
+	if got := titles(); len(got) < 2 {
+		t.Fatalf("expected the max wait to force dispatches during the stream, got %v", got)
+	}
+}
+
+func TestCoalescer_UnkeyedEventsPassThrough(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), time.Hour)
+	defer c.Shutdown(context.Background())
+
+	_ = c.Submit(Event{
+		Metadata: Metadata{Topic: TopicProductsUpdate},
+		RawBody:  []byte(`{"title":"no id"}`),
+	}, router)
+
+	waitFor(t, func() bool { return len(titles()) == 1 })
+}
+
+func TestCoalescer_ShutdownFlushesPending(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), time.Hour)
+
+	_ = c.Submit(productEvent("1", "held"), router)
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(); len(got) != 1 || got[0] != "held" {
+		t.Fatalf("expected the held event to be processed on shutdown, got %v", got)
+	}
+	if err := c.Submit(productEvent("1", "late"), router); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestCoalescer_MaxPendingRejects(t *testing.T) {
+	router, _ := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), time.Hour, WithMaxPending(2))
+	defer c.Shutdown(context.Background())
+
+	_ = c.Submit(productEvent("1", "a"), router)
+	_ = c.Submit(productEvent("2", "b"), router)
+	if err := c.Submit(productEvent("1", "a2"), router); err != nil {
+		t.Fatalf("expected a held key to accept more events, got %v", err)
+	}
+	if err := c.Submit(productEvent("3", "c"), router); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull for a new key, got %v", err)
+	}
+}
+
+func TestCoalescer_MaxWaitShorterThanWindow(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), time.Hour, WithMaxWait(20*time.Millisecond))
+	defer c.Shutdown(context.Background())
+
+	_ = c.Submit(productEvent("1", "a"), router)
+	waitFor(t, func() bool { return len(titles()) == 1 })
+}
+
+func TestCoalescer_ZeroWindowPassesThrough(t *testing.T) {
+	router, titles := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), 0)
+	defer c.Shutdown(context.Background())
+
+	_ = c.Submit(productEvent("1", "a"), router)
+	_ = c.Submit(productEvent("1", "b"), router)
+	waitFor(t, func() bool { return len(titles()) == 2 })
+}
+
+func TestCoalescer_KeepsRoutersApart(t *testing.T) {
+	routerA, titlesA := titleRouter()
+	routerB, titlesB := titleRouter()
+	c := NewCoalescer(NewWorkerPool(1, 10), time.Hour)
+
+	_ = c.Submit(productEvent("1", "a"), routerA)
+	_ = c.Submit(productEvent("1", "b"), routerB)
+	_ = c.Shutdown(context.Background())
+
+	if a, b := titlesA(), titlesB(); len(a) != 1 || a[0] != "a" || len(b) != 1 || b[0] != "b" {
+		t.Fatalf("expected each router to get its own event, got %v and %v", a, b)
+	}
+}
+
+func TestCoalescer_RetriesWhenInnerIsFull(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	var titles []string
+	var mu sync.Mutex
+	router := NewRouter()
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		started <- struct{}{}
+		<-release
+		var p Product
+		_ = event.Unmarshal(&p)
+		mu.Lock()
+		titles = append(titles, p.Title)
+		mu.Unlock()
+		return nil
+	})
+
+	var reported atomic.Int32
+	pool := NewWorkerPool(1, 1)
+	c := NewCoalescer(pool, 10*time.Millisecond, WithCoalesceErrorHandler(func(Event, error) {
+		reported.Add(1)
+	}))
+
+	// Occupy the worker and the queue, so the window closes on a full pool.
+	_ = pool.Submit(productEvent("1", "busy"), router)
+	<-started
+	_ = pool.Submit(productEvent("2", "queued"), router)
+	_ = c.Submit(productEvent("3", "coalesced"), router)
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	_ = c.Shutdown(context.Background())
+
+	if reported.Load() != 0 {
+		t.Fatal("expected a full pool not to be reported")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(titles) != 3 || titles[2] != "coalesced" {
+		t.Fatalf("expected the coalesced event to be processed once there was room, got %v", titles)
+	}
+}
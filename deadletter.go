@@ -0,0 +1,271 @@
+package shopifywebhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an event that a WorkerPool gave up on, with enough context
+// to investigate and replay it.
+type DeadLetter struct {
+	// Event is the full event, including its raw body.
+	Event Event `json:"event"`
+
+	// Error is the last attempt's error.
+	Error string `json:"error"`
+
+	// FailedSubscribers lists, for a topic with several subscribers, the
+	// indices of those whose last attempt failed (see FanoutError). Only
+	// these are run again on replay. It is empty if the event as a whole
+	// failed.
+	FailedSubscribers []int `json:"failed_subscribers,omitempty"`
+
+	// Attempts records each failed attempt, oldest first.
+	Attempts []DeadLetterAttempt `json:"attempts,omitempty"`
+
+	// Time is when the event was dead-lettered.
+	Time time.Time `json:"time"`
+}
+
+// DeadLetterAttempt is one failed attempt at processing a DeadLetter.
+type DeadLetterAttempt struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// DeadLetterSink records events that a WorkerPool gave up on, instead of
+// only reporting them to the error handler. Configure one with
+// WithDeadLetterSink.
+type DeadLetterSink interface {
+	Put(ctx context.Context, letter DeadLetter) error
+}
+
+// ReplayDeadLetters dispatches each letter's event through router again
+// and returns the letters that failed again, with the new attempt added.
+// As with Router.RetryFailed, only a letter's FailedSubscribers are run
+// again, so subscribers that already succeeded don't process it twice.
+// It stops early if ctx is cancelled, returning the unreplayed letters as
+// failed.
+func ReplayDeadLetters(ctx context.Context, router *Router, letters []DeadLetter) []DeadLetter {
+	var failed []DeadLetter
+	for i, letter := range letters {
+		if ctx.Err() != nil {
+			return append(failed, letters[i:]...)
+		}
+		if err := router.RetryFailed(ctx, letter.Event, letter.lastErr()); err != nil {
+			now := time.Now()
+			letter.Error = err.Error()
+			letter.FailedSubscribers = failedSubscribers(err)
+			letter.Attempts = append(letter.Attempts, DeadLetterAttempt{Time: now, Error: err.Error()})
+			letter.Time = now
+			failed = append(failed, letter)
+		}
+	}
+	return failed
+}
+
+// lastErr rebuilds the last attempt's error as far as RetryFailed needs:
+// a *FanoutError naming the failed subscribers, if there were any.
+func (l DeadLetter) lastErr() error {
+	err := errors.New(l.Error)
+	if len(l.FailedSubscribers) == 0 {
+		return err
+	}
+	fanoutErr := &FanoutError{Errors: make([]*SubscriberError, len(l.FailedSubscribers))}
+	for i, index := range l.FailedSubscribers {
+		fanoutErr.Errors[i] = &SubscriberError{Index: index, Err: err}
+	}
+	return fanoutErr
+}
+
+// failedSubscribers returns the indices of the subscribers that failed if
+// err is a *FanoutError, or nil.
+func failedSubscribers(err error) []int {
+	var fanoutErr *FanoutError
+	if !errors.As(err, &fanoutErr) {
+		return nil
+	}
+	return fanoutErr.failed()
+}
+
+// FileDeadLetterSink is a DeadLetterSink that appends dead letters to a
+// file as JSON Lines, one DeadLetter per line. It is safe for concurrent
+// use by multiple goroutines.
+type FileDeadLetterSink struct {
+	replayMu sync.Mutex // serializes Replay
+
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenDeadLetterFile opens or creates the dead-letter file at path. A final
+// line torn by a crash mid-write is truncated away.
+func OpenDeadLetterFile(path string) (*FileDeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("shopifywebhook: open dead-letter file: %w", err)
+	}
+	s := &FileDeadLetterSink{path: path, file: f}
+	if _, err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put appends letter to the file and syncs it to disk.
+func (s *FileDeadLetterSink) Put(_ context.Context, letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("shopifywebhook: write dead letter: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("shopifywebhook: sync dead letter: %w", err)
+	}
+	return nil
+}
+
+// Load returns the dead letters in the file, oldest first.
+func (s *FileDeadLetterSink) Load() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileDeadLetterSink) load() ([]DeadLetter, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("shopifywebhook: read dead letters: %w", err)
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+	r := bufio.NewReader(f)
+	var size int64 // bytes up to the end of the last complete line
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) > 0 {
+				// Torn by a crash mid-write: drop it so the next Put
+				// doesn't append onto it.
+				if err := os.Truncate(s.path, size); err != nil {
+					return nil, fmt.Errorf("shopifywebhook: repair dead letters: %w", err)
+				}
+			}
+			return letters, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("shopifywebhook: read dead letters: %w", err)
+		}
+		size += int64(len(b))
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(b, &letter); err != nil {
+			return nil, fmt.Errorf("shopifywebhook: read dead letters: line %d: %w", line, err)
+		}
+		letters = append(letters, letter)
+	}
+}
+
+// Replay dispatches every dead letter in the file through router (see
+// ReplayDeadLetters) and rewrites the file with only the letters that
+// failed again. It returns how many were replayed successfully and how
+// many remain. Letters put while a replay is dispatching are kept, but not
+// replayed until the next one.
+func (s *FileDeadLetterSink) Replay(ctx context.Context, router *Router) (replayed, remaining int, err error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	letters, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Dispatch without holding s.mu, so Put isn't blocked by slow handlers.
+	failed := ReplayDeadLetters(ctx, router, letters)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.load()
+	if err != nil {
+		return 0, len(letters), err
+	}
+	// Only Put changes the file between the two loads, and it only appends.
+	remain := failed
+	if len(current) > len(letters) {
+		remain = append(remain, current[len(letters):]...)
+	}
+	if err := s.rewrite(remain); err != nil {
+		return 0, len(letters), err
+	}
+	return len(letters) - len(failed), len(remain), nil
+}
+
+// rewrite atomically replaces the file with letters and reopens it for
+// appending. Callers must hold s.mu.
+func (s *FileDeadLetterSink) rewrite(letters []DeadLetter) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".replay-*")
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, letter := range letters {
+		if err := enc.Encode(letter); err != nil {
+			tmp.Close()
+			return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("shopifywebhook: rewrite dead letters: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: open dead-letter file: %w", err)
+	}
+	s.file.Close()
+	s.file = f
+	return nil
+}
+
+// Close closes the file.
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
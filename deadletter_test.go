@@ -0,0 +1,246 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_DeadLettersExhaustedEvents(t *testing.T) {
+	sink, err := OpenDeadLetterFile(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		return errors.New("downstream unavailable")
+	})
+
+	pool := NewWorkerPool(1, 10,
+		WithMaxRetries(2),
+		WithRetryBaseDelay(time.Millisecond),
+		WithDeadLetterSink(sink),
+	)
+	event := Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate, ShopDomain: "a.myshopify.com", EventID: "evt-1"},
+		RawBody:  []byte(`{"id":1}`),
+	}
+	pool.Submit(event, router)
+	_ = pool.Shutdown(context.Background())
+
+	letters, err := sink.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	letter := letters[0]
+	if letter.Event.Metadata.EventID != "evt-1" || string(letter.Event.RawBody) != `{"id":1}` {
+		t.Fatalf("expected the full event to be recorded, got %+v", letter.Event)
+	}
+	if letter.Error != "downstream unavailable" {
+		t.Fatalf("unexpected error: %q", letter.Error)
+	}
+	if len(letter.Attempts) != 3 {
+		t.Fatalf("expected 3 recorded attempts, got %d", len(letter.Attempts))
+	}
+}
+
+func TestWorkerPool_DoesNotDeadLetterRejectedEvents(t *testing.T) {
+	var letters []DeadLetter
+	sink := deadLetterFunc(func(_ context.Context, letter DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 10, WithDeadLetterSink(sink))
+	_ = pool.Shutdown(context.Background())
+	err := pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, NewRouter())
+
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+	if len(letters) != 0 {
+		t.Fatalf("expected the rejected event to be left to the caller, got %+v", letters)
+	}
+}
+
+func TestFileDeadLetterSink_Replay(t *testing.T) {
+	sink, err := OpenDeadLetterFile(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	ctx := context.Background()
+
+	for _, topic := range []Topic{TopicOrdersCreate, TopicProductsUpdate} {
+		letter := DeadLetter{
+			Event:    Event{Metadata: Metadata{Topic: topic}, RawBody: []byte(`{}`)},
+			Error:    "failed",
+			Attempts: []DeadLetterAttempt{{Time: time.Now(), Error: "failed"}},
+		}
+		if err := sink.Put(ctx, letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var orders atomic.Int32
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		orders.Add(1)
+		return nil
+	})
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		return errors.New("still failing")
+	})
+
+	replayed, remaining, err := sink.Replay(ctx, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 || remaining != 1 || orders.Load() != 1 {
+		t.Fatalf("expected 1 replayed and 1 remaining, got %d and %d", replayed, remaining)
+	}
+
+	// New letters are appended after the rewritten file.
+	_ = sink.Put(ctx, DeadLetter{Event: Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, Error: "x"})
+
+	letters, err := sink.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 letters, got %d", len(letters))
+	}
+	if got := letters[0]; got.Error != "still failing" || len(got.Attempts) != 2 {
+		t.Fatalf("expected the failed replay to be recorded, got %+v", got)
+	}
+}
+
+func TestFileDeadLetterSink_PutDuringReplay(t *testing.T) {
+	sink, err := OpenDeadLetterFile(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	ctx := context.Background()
+
+	_ = sink.Put(ctx, DeadLetter{Event: Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, Error: "failed"})
+
+	// The handler dead-letters another event while the replay dispatches,
+	// as a WorkerPool worker would.
+	put := make(chan error, 1)
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		go func() {
+			put <- sink.Put(ctx, DeadLetter{Event: Event{Metadata: Metadata{Topic: TopicProductsUpdate}}, Error: "new"})
+		}()
+		select {
+		case err := <-put:
+			return err
+		case <-time.After(time.Second):
+			return errors.New("Put blocked by Replay")
+		}
+	})
+
+	replayed, remaining, err := sink.Replay(ctx, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 || remaining != 1 {
+		t.Fatalf("expected 1 replayed and 1 remaining, got %d and %d", replayed, remaining)
+	}
+	letters, _ := sink.Load()
+	if len(letters) != 1 || letters[0].Error != "new" {
+		t.Fatalf("expected the letter put during the replay to be kept, got %+v", letters)
+	}
+}
+
+func TestFileDeadLetterSink_TornFinalLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	ctx := context.Background()
+
+	sink, err := OpenDeadLetterFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sink.Put(ctx, DeadLetter{Event: Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, Error: "first"})
+	_ = sink.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = f.WriteString(`{"event":{"meta`)
+	_ = f.Close()
+
+	sink, err = OpenDeadLetterFile(path)
+	if err != nil {
+		t.Fatalf("expected a torn final line to be tolerated: %v", err)
+	}
+	defer sink.Close()
+	_ = sink.Put(ctx, DeadLetter{Event: Event{Metadata: Metadata{Topic: TopicOrdersCreate}}, Error: "second"})
+
+	letters, err := sink.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[1].Error != "second" {
+		t.Fatalf("expected both complete letters, got %+v", letters)
+	}
+}
+
+type deadLetterFunc func(ctx context.Context, letter DeadLetter) error
+
+func (f deadLetterFunc) Put(ctx context.Context, letter DeadLetter) error {
+	return f(ctx, letter)
+}
+
+func TestReplayDeadLetters_OnlyFailedSubscribers(t *testing.T) {
+	sink, err := OpenDeadLetterFile(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	var billing, analytics atomic.Int32
+	var broken atomic.Bool
+	broken.Store(true)
+	router := NewRouter(WithFanout(FanoutSequential))
+	router.Subscribe(TopicOrdersPaid, "billing", func(_ context.Context, event Event) error {
+		billing.Add(1)
+		return nil
+	})
+	router.Subscribe(TopicOrdersPaid, "analytics", func(_ context.Context, event Event) error {
+		analytics.Add(1)
+		if broken.Load() {
+			return errors.New("analytics down")
+		}
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 10, WithDeadLetterSink(sink))
+	_ = pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersPaid}, RawBody: []byte(`{}`)}, router)
+	_ = pool.Shutdown(context.Background())
+
+	letters, err := sink.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || len(letters[0].FailedSubscribers) != 1 || letters[0].FailedSubscribers[0] != 1 {
+		t.Fatalf("expected the failed subscriber to be recorded, got %+v", letters)
+	}
+
+	broken.Store(false)
+	if failed := ReplayDeadLetters(context.Background(), router, letters); len(failed) != 0 {
+		t.Fatalf("expected the replay to succeed, got %+v", failed)
+	}
+	if billing.Load() != 1 || analytics.Load() != 2 {
+		t.Fatalf("expected only the failed subscriber to run again, got billing %d and analytics %d",
+			billing.Load(), analytics.Load())
+	}
+}
@@ -1,8 +1,11 @@
 package shopifywebhook
 
 import (
+	"container/list"
 	"context"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,74 +23,425 @@ type IdempotencyStore interface {
 	Store(ctx context.Context, eventID string) error
 }
 
-// MemoryStore is an in-memory IdempotencyStore suitable for
+// DefaultClaimLease is how long Handler holds a claim on an event unless
+// configured otherwise with WithClaimLease.
+const DefaultClaimLease = 5 * time.Minute
+
+// ClaimStore tracks webhook events through an atomic claim lifecycle, so
+// concurrent deliveries of the same event cannot both be processed:
+//
+//  1. Claim reserves the event before it is dispatched.
+//  2. Complete marks it processed once the handler succeeds.
+//  3. Release gives up the claim if the handler fails, so Shopify's retry
+//     can process it again.
+//
+// A claim that is neither completed nor released expires after its lease,
+// which covers a process crashing mid-dispatch.
+//
+// Implement this interface for your storage backend:
+//   - Redis: SET key claimed NX PX lease, then SET key done PX ttl
+//   - PostgreSQL: INSERT ... ON CONFLICT DO UPDATE ... WHERE lease expired
+//
+// Handler uses a ClaimStore directly when one is configured; a plain
+// IdempotencyStore is adapted with AdaptIdempotencyStore. The contexts
+// Handler passes to a store carry the event being deduplicated, available
+// through EventFromContext.
+type ClaimStore interface {
+	// Claim reserves eventID for processing for up to lease. It returns
+	// false if the event has already been completed, or false and
+	// ErrAlreadyClaimed if it is claimed by someone else whose lease has
+	// not expired.
+	Claim(ctx context.Context, eventID string, lease time.Duration) (bool, error)
+
+	// Complete marks a claimed event as processed.
+	Complete(ctx context.Context, eventID string) error
+
+	// Release drops the claim on an event that was not processed, making
+	// it eligible to be claimed again.
+	Release(ctx context.Context, eventID string) error
+}
+
+// AdaptIdempotencyStore returns a ClaimStore backed by an IdempotencyStore.
+// If s already implements ClaimStore, it is returned unchanged.
+//
+// Exists and Store cannot make a claim atomic across processes, but the
+// adapter tracks in-flight claims in memory, so concurrent deliveries
+// reaching the same process are still only processed once.
+func AdaptIdempotencyStore(s IdempotencyStore) ClaimStore {
+	if cs, ok := s.(ClaimStore); ok {
+		return cs
+	}
+	return &idempotencyAdapter{store: s, inflight: make(map[string]time.Time)}
+}
+
+type idempotencyAdapter struct {
+	store    IdempotencyStore
+	mu       sync.Mutex
+	inflight map[string]time.Time // event ID -> lease expiry
+}
+
+func (a *idempotencyAdapter) Claim(ctx context.Context, eventID string, lease time.Duration) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if expires, ok := a.inflight[eventID]; ok && time.Now().Before(expires) {
+		return false, ErrAlreadyClaimed
+	}
+	exists, err := a.store.Exists(ctx, eventID)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	a.inflight[eventID] = time.Now().Add(lease)
+	return true, nil
+}
+
+func (a *idempotencyAdapter) Complete(ctx context.Context, eventID string) error {
+	err := a.store.Store(ctx, eventID)
+	a.mu.Lock()
+	delete(a.inflight, eventID)
+	a.mu.Unlock()
+	return err
+}
+
+func (a *idempotencyAdapter) Release(_ context.Context, eventID string) error {
+	a.mu.Lock()
+	delete(a.inflight, eventID)
+	a.mu.Unlock()
+	return nil
+}
+
+// MemoryStore is an in-memory IdempotencyStore and ClaimStore suitable for
 // single-instance deployments.
 //
-// Entries automatically expire after the configured TTL to prevent
-// unbounded memory growth. A background goroutine evicts expired
-// entries periodically.
+// Entries automatically expire after the configured TTL. The store is split
+// into shards with their own locks, and a background goroutine periodically
+// sweeps every shard for expired entries, a bounded batch at a time, so
+// neither lookups nor the sweep contend on one lock across the whole store.
+// Use WithMaxEntries to cap memory during bursts of distinct events: the
+// least recently used completed entries are evicted to make room.
 type MemoryStore struct {
-	mu      sync.RWMutex
-	entries map[string]time.Time
-	ttl     time.Duration
-	done    chan struct{}
+	shards []*memoryShard
+	seed   maphash.Seed
+	ttl    time.Duration
+	done   chan struct{}
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+type memoryEntry struct {
+	expires   time.Time
+	completed bool // false while the event is only claimed
+}
+
+// memoryShard is an LRU list of entries, most recently used at the front.
+type memoryShard struct {
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      list.List     // of *memoryItem
+	capacity int           // 0 means unbounded
+	cursor   *list.Element // next entry the running sweep examines, if any
+}
+
+type memoryItem struct {
+	id string
+	memoryEntry
+}
+
+// MemoryStoreStats reports a MemoryStore's activity since it was created.
+type MemoryStoreStats struct {
+	// Hits counts lookups that found an unexpired entry: Exists calls
+	// returning true and Claim calls refused as duplicates.
+	Hits int64
+
+	// Misses counts lookups that found no unexpired entry.
+	Misses int64
+
+	// Evictions counts entries dropped to stay within WithMaxEntries.
+	// Expired entries that are swept are not counted.
+	Evictions int64
+
+	// Size is the number of entries held, including expired entries that
+	// have not been swept yet.
+	Size int
 }
 
+// MemoryStoreOption configures a MemoryStore.
+type MemoryStoreOption func(*memoryStoreConfig)
+
+type memoryStoreConfig struct {
+	maxEntries    int
+	shards        int
+	sweepInterval time.Duration
+}
+
+// WithMaxEntries caps the number of entries the store holds, split evenly
+// across its shards. When a shard is full, its least recently used
+// completed entry is evicted, which may let a duplicate of that event
+// through. Claimed entries are never evicted, as that would let a
+// concurrent delivery of an event still being processed run too, so a
+// shard may exceed its share of the cap while all its entries are claimed.
+// Default: no cap.
+func WithMaxEntries(n int) MemoryStoreOption {
+	return func(c *memoryStoreConfig) {
+		c.maxEntries = n
+	}
+}
+
+// WithShards sets how many independently locked shards the store is split
+// into. Default: 16.
+func WithShards(n int) MemoryStoreOption {
+	return func(c *memoryStoreConfig) {
+		c.shards = n
+	}
+}
+
+// WithSweepInterval sets how often expired entries are swept from the
+// whole store. Default: 1 minute.
+func WithSweepInterval(d time.Duration) MemoryStoreOption {
+	return func(c *memoryStoreConfig) {
+		c.sweepInterval = d
+	}
+}
+
+// memorySweepBatch bounds how many entries the background sweep examines
+// per shard before releasing the shard's lock.
+const memorySweepBatch = 1024
+
 // NewMemoryStore creates a MemoryStore with the given TTL.
 //
 // Typical TTL: 24 hours. Shopify retries for up to 48 hours,
 // but 24h catches the vast majority of duplicates.
-func NewMemoryStore(ttl time.Duration) *MemoryStore {
+func NewMemoryStore(ttl time.Duration, opts ...MemoryStoreOption) *MemoryStore {
+	cfg := &memoryStoreConfig{shards: 16, sweepInterval: time.Minute}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cfg.shards = max(cfg.shards, 1)
+	if cfg.sweepInterval <= 0 {
+		cfg.sweepInterval = time.Minute
+	}
+
+	capacity := 0
+	if cfg.maxEntries > 0 {
+		capacity = max((cfg.maxEntries+cfg.shards-1)/cfg.shards, 1)
+	}
+
 	s := &MemoryStore{
-		entries: make(map[string]time.Time),
-		ttl:     ttl,
-		done:    make(chan struct{}),
+		shards: make([]*memoryShard, cfg.shards),
+		seed:   maphash.MakeSeed(),
+		ttl:    ttl,
+		done:   make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &memoryShard{
+			entries:  make(map[string]*list.Element),
+			capacity: capacity,
+		}
 	}
-	go s.cleanup(ttl / 2)
+	go s.cleanup(cfg.sweepInterval)
 	return s
 }
 
-// Exists checks if the event ID has been seen within the TTL window.
+func (s *MemoryStore) shard(eventID string) *memoryShard {
+	return s.shards[maphash.String(s.seed, eventID)%uint64(len(s.shards))]
+}
+
+// Exists checks if the event ID has been completed within the TTL window.
+// Events that are only claimed are not reported as existing.
 func (s *MemoryStore) Exists(_ context.Context, eventID string) (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	ts, ok := s.entries[eventID]
-	if !ok {
+	sh := s.shard(eventID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	item, ok := sh.get(eventID, time.Now())
+	if !ok || !item.completed {
+		s.misses.Add(1)
 		return false, nil
 	}
-	if time.Since(ts) > s.ttl {
+	s.hits.Add(1)
+	return true, nil
+}
+
+// Store records an event ID as completed with the current timestamp.
+func (s *MemoryStore) Store(_ context.Context, eventID string) error {
+	sh := s.shard(eventID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	s.evictions.Add(int64(sh.set(eventID, memoryEntry{expires: time.Now().Add(s.ttl), completed: true})))
+	return nil
+}
+
+// Claim atomically reserves the event ID for lease. It fails if the event
+// was completed within the TTL, or with ErrAlreadyClaimed if it is claimed
+// under an unexpired lease.
+func (s *MemoryStore) Claim(_ context.Context, eventID string, lease time.Duration) (bool, error) {
+	sh := s.shard(eventID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := time.Now()
+	if item, ok := sh.get(eventID, now); ok {
+		s.hits.Add(1)
+		if !item.completed {
+			return false, ErrAlreadyClaimed
+		}
 		return false, nil
 	}
+	s.misses.Add(1)
+	s.evictions.Add(int64(sh.set(eventID, memoryEntry{expires: now.Add(lease)})))
 	return true, nil
 }
 
-// Store records an event ID with the current timestamp.
-func (s *MemoryStore) Store(_ context.Context, eventID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.entries[eventID] = time.Now()
+// Complete marks the event ID as processed for the store's TTL.
+func (s *MemoryStore) Complete(ctx context.Context, eventID string) error {
+	return s.Store(ctx, eventID)
+}
+
+// Release drops an uncompleted claim on the event ID.
+func (s *MemoryStore) Release(_ context.Context, eventID string) error {
+	sh := s.shard(eventID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if el, ok := sh.entries[eventID]; ok && !el.Value.(*memoryItem).completed {
+		sh.remove(el)
+	}
 	return nil
 }
 
+// Stats returns the store's counters and current size.
+func (s *MemoryStore) Stats() MemoryStoreStats {
+	size := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		size += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return MemoryStoreStats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+		Size:      size,
+	}
+}
+
 // Close stops the background cleanup goroutine.
 func (s *MemoryStore) Close() {
 	close(s.done)
 }
 
+// get returns the unexpired entry for id and marks it recently used. An
+// expired entry is removed. Callers must hold sh.mu.
+func (sh *memoryShard) get(id string, now time.Time) (*memoryItem, bool) {
+	el, ok := sh.entries[id]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*memoryItem)
+	if !now.Before(item.expires) {
+		sh.remove(el)
+		return nil, false
+	}
+	sh.touch(el)
+	return item, true
+}
+
+// set stores e for id as the most recently used entry and returns how many
+// entries were evicted to make room. Callers must hold sh.mu.
+func (sh *memoryShard) set(id string, e memoryEntry) int {
+	if el, ok := sh.entries[id]; ok {
+		el.Value.(*memoryItem).memoryEntry = e
+		sh.touch(el)
+		return 0
+	}
+	newest := sh.lru.PushFront(&memoryItem{id: id, memoryEntry: e})
+	sh.entries[id] = newest
+	if sh.capacity == 0 || len(sh.entries) <= sh.capacity {
+		return 0
+	}
+
+	// Evict completed entries from the least recently used end, skipping
+	// claims; expired entries of either kind are dropped along the way
+	// without counting as evictions.
+	evicted := 0
+	now := time.Now()
+	for el := sh.lru.Back(); el != newest && len(sh.entries) > sh.capacity; {
+		prev := el.Prev()
+		item := el.Value.(*memoryItem)
+		if !now.Before(item.expires) {
+			sh.remove(el)
+		} else if item.completed {
+			sh.remove(el)
+			evicted++
+		}
+		el = prev
+	}
+	return evicted
+}
+
+// touch marks el as the most recently used entry. Callers must hold sh.mu.
+func (sh *memoryShard) touch(el *list.Element) {
+	sh.skip(el)
+	sh.lru.MoveToFront(el)
+}
+
+// remove drops el from the shard. Callers must hold sh.mu.
+func (sh *memoryShard) remove(el *list.Element) {
+	sh.skip(el)
+	sh.lru.Remove(el)
+	delete(sh.entries, el.Value.(*memoryItem).id)
+}
+
+// skip moves a running sweep's cursor past el, before el is moved or
+// removed. Callers must hold sh.mu.
+func (sh *memoryShard) skip(el *list.Element) {
+	if sh.cursor == el {
+		sh.cursor = el.Prev()
+	}
+}
+
+// sweep removes every expired entry from the shard. It walks the LRU list
+// from the least recently used end, releasing the lock after each
+// memorySweepBatch entries so lookups aren't held up for long. Entries used
+// while the sweep runs move ahead of its cursor and are skipped until the
+// next sweep, as they were just found unexpired.
+func (sh *memoryShard) sweep(now time.Time) {
+	sh.mu.Lock()
+	sh.cursor = sh.lru.Back()
+	sh.mu.Unlock()
+	for sh.sweepBatch(now) {
+	}
+}
+
+// sweepBatch examines up to memorySweepBatch entries from the sweep's
+// cursor. It returns false once the sweep has reached the front.
+func (sh *memoryShard) sweepBatch(now time.Time) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for range memorySweepBatch {
+		el := sh.cursor
+		if el == nil {
+			return false
+		}
+		sh.cursor = el.Prev()
+		if !now.Before(el.Value.(*memoryItem).expires) {
+			sh.remove(el)
+		}
+	}
+	return sh.cursor != nil
+}
+
 func (s *MemoryStore) cleanup(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			s.mu.Lock()
 			now := time.Now()
-			for id, ts := range s.entries {
-				if now.Sub(ts) > s.ttl {
-					delete(s.entries, id)
-				}
+			for _, sh := range s.shards {
+				sh.sweep(now)
 			}
-			s.mu.Unlock()
 		case <-s.done:
 			return
 		}
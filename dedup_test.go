@@ -2,6 +2,10 @@ package shopifywebhook
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -54,7 +58,7 @@ func TestMemoryStore_TTLExpiry(t *testing.T) {
 
 func TestMemoryStore_Cleanup(t *testing.T) {
 	// TTL=50ms, cleanup runs every 25ms.
-	store := NewMemoryStore(50 * time.Millisecond)
+	store := NewMemoryStore(50*time.Millisecond, WithSweepInterval(25*time.Millisecond))
 	defer store.Close()
 	ctx := context.Background()
 
@@ -64,11 +68,7 @@ func TestMemoryStore_Cleanup(t *testing.T) {
 	// Wait for TTL + cleanup interval.
 	time.Sleep(150 * time.Millisecond)
 
-	store.mu.RLock()
-	count := len(store.entries)
-	store.mu.RUnlock()
-
-	if count != 0 {
+	if count := store.Stats().Size; count != 0 {
 		t.Fatalf("expected 0 entries after cleanup, got %d", count)
 	}
 }
@@ -92,3 +92,237 @@ func TestMemoryStore_MultipleEvents(t *testing.T) {
 		t.Fatal("expected c to not exist")
 	}
 }
+
+func TestMemoryStore_ClaimLifecycle(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	ctx := context.Background()
+
+	ok, err := store.Claim(ctx, "evt", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected first claim to succeed, got ok=%v err=%v", ok, err)
+	}
+
+	ok, err = store.Claim(ctx, "evt", time.Minute)
+	if ok || !errors.Is(err, ErrAlreadyClaimed) {
+		t.Fatalf("expected second claim to fail with ErrAlreadyClaimed while the first is held, got ok=%v err=%v", ok, err)
+	}
+
+	exists, _ := store.Exists(ctx, "evt")
+	if exists {
+		t.Fatal("a claimed event should not be reported as processed")
+	}
+
+	_ = store.Release(ctx, "evt")
+	ok, _ = store.Claim(ctx, "evt", time.Minute)
+	if !ok {
+		t.Fatal("expected claim to succeed after release")
+	}
+
+	_ = store.Complete(ctx, "evt")
+	ok, err = store.Claim(ctx, "evt", time.Minute)
+	if ok || err != nil {
+		t.Fatalf("expected claim to fail without error after completion, got ok=%v err=%v", ok, err)
+	}
+	_ = store.Release(ctx, "evt")
+	exists, _ = store.Exists(ctx, "evt")
+	if !exists {
+		t.Fatal("release must not undo a completed event")
+	}
+}
+
+func TestMemoryStore_ClaimLeaseExpires(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	ctx := context.Background()
+
+	_, _ = store.Claim(ctx, "evt", 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+
+	ok, _ := store.Claim(ctx, "evt", time.Minute)
+	if !ok {
+		t.Fatal("expected claim to succeed after lease expired")
+	}
+}
+
+func TestMemoryStore_ConcurrentClaims(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := store.Claim(context.Background(), "evt", time.Minute); ok {
+				wins.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := wins.Load(); got != 1 {
+		t.Fatalf("expected exactly 1 successful claim, got %d", got)
+	}
+}
+
+// existsStore is an IdempotencyStore without claim support.
+type existsStore struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (s *existsStore) Exists(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[id], nil
+}
+
+func (s *existsStore) Store(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[id] = true
+	return nil
+}
+
+func TestAdaptIdempotencyStore(t *testing.T) {
+	backing := &existsStore{seen: map[string]bool{}}
+	store := AdaptIdempotencyStore(backing)
+	ctx := context.Background()
+
+	ok, _ := store.Claim(ctx, "evt", time.Minute)
+	if !ok {
+		t.Fatal("expected first claim to succeed")
+	}
+	ok, _ = store.Claim(ctx, "evt", time.Minute)
+	if ok {
+		t.Fatal("expected in-flight claim to block a second claim")
+	}
+
+	_ = store.Complete(ctx, "evt")
+	if !backing.seen["evt"] {
+		t.Fatal("expected Complete to Store the event")
+	}
+	ok, _ = store.Claim(ctx, "evt", time.Minute)
+	if ok {
+		t.Fatal("expected claim to fail for a stored event")
+	}
+
+	mem := NewMemoryStore(time.Hour)
+	defer mem.Close()
+	if AdaptIdempotencyStore(mem) != ClaimStore(mem) {
+		t.Fatal("expected a ClaimStore to be returned unchanged")
+	}
+}
+
+func TestMemoryStore_MaxEntriesEvictsLRU(t *testing.T) {
+	store := NewMemoryStore(time.Hour, WithMaxEntries(2), WithShards(1))
+	defer store.Close()
+	ctx := context.Background()
+
+	_ = store.Store(ctx, "a")
+	_ = store.Store(ctx, "b")
+	_, _ = store.Exists(ctx, "a") // a is now more recently used than b
+	_ = store.Store(ctx, "c")
+
+	if ok, _ := store.Exists(ctx, "b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if ok, _ := store.Exists(ctx, id); !ok {
+			t.Fatalf("expected %s to be kept", id)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Size != 2 || stats.Evictions != 1 {
+		t.Fatalf("expected size 2 and 1 eviction, got %+v", stats)
+	}
+}
+
+func TestMemoryStore_Stats(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	ctx := context.Background()
+
+	_, _ = store.Claim(ctx, "evt", time.Minute) // miss
+	_, _ = store.Claim(ctx, "evt", time.Minute) // hit
+	_, _ = store.Exists(ctx, "evt")             // miss: only claimed
+	_ = store.Complete(ctx, "evt")
+	_, _ = store.Exists(ctx, "evt") // hit
+
+	stats := store.Stats()
+	want := MemoryStoreStats{Hits: 2, Misses: 2, Size: 1}
+	if stats != want {
+		t.Fatalf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestMemoryStore_MaxEntriesKeepsClaims(t *testing.T) {
+	store := NewMemoryStore(time.Hour, WithMaxEntries(2), WithShards(1))
+	defer store.Close()
+	ctx := context.Background()
+
+	_, _ = store.Claim(ctx, "a", time.Minute)
+	_, _ = store.Claim(ctx, "b", time.Minute)
+	_ = store.Store(ctx, "c")
+	_, _ = store.Claim(ctx, "d", time.Minute)
+
+	// c was the only completed entry, so it is evicted even though it is
+	// more recently used than the claims.
+	for _, id := range []string{"a", "b", "d"} {
+		if ok, _ := store.Claim(ctx, id, time.Minute); ok {
+			t.Fatalf("expected the claim on %s to be kept", id)
+		}
+	}
+	if ok, _ := store.Exists(ctx, "c"); ok {
+		t.Fatal("expected the completed entry to be evicted")
+	}
+	if stats := store.Stats(); stats.Size != 3 || stats.Evictions != 1 {
+		t.Fatalf("expected size 3 and 1 eviction, got %+v", stats)
+	}
+}
+
+func TestMemoryStore_SweepIsBatched(t *testing.T) {
+	store := NewMemoryStore(time.Hour, WithShards(1))
+	store.Close() // sweep manually
+	ctx := context.Background()
+
+	for i := range 3 * memorySweepBatch {
+		_, _ = store.Claim(ctx, fmt.Sprint(i), time.Nanosecond)
+	}
+	time.Sleep(time.Millisecond)
+	now := time.Now()
+
+	sh := store.shards[0]
+	sh.mu.Lock()
+	sh.cursor = sh.lru.Back()
+	sh.mu.Unlock()
+	if !sh.sweepBatch(now) {
+		t.Fatal("expected the sweep to need more batches")
+	}
+	if got := store.Stats().Size; got != 2*memorySweepBatch {
+		t.Fatalf("expected one batch to remove %d entries, %d left", memorySweepBatch, got)
+	}
+
+	// Use the store between batches: the entry under the cursor is
+	// replaced and a new one is added.
+	sh.mu.Lock()
+	next := sh.cursor.Value.(*memoryItem).id
+	sh.mu.Unlock()
+	_ = store.Store(ctx, next)
+	_ = store.Store(ctx, "new")
+
+	for sh.sweepBatch(now) {
+	}
+	stats := store.Stats()
+	if stats.Size != 2 {
+		t.Fatalf("expected the sweep to remove every expired entry, %d left", stats.Size)
+	}
+	for _, id := range []string{next, "new"} {
+		if ok, _ := store.Exists(ctx, id); !ok {
+			t.Fatalf("expected %s to be kept", id)
+		}
+	}
+}
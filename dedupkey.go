@@ -0,0 +1,80 @@
+package shopifywebhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// DedupKeyFunc derives the key Handler deduplicates an event on.
+//
+// An empty key means the event cannot be deduplicated: Handler processes it
+// without claiming, rather than collapsing every such event onto one key.
+type DedupKeyFunc func(event Event) string
+
+// EventIDKey dedups on the X-Shopify-Event-Id header, which Shopify keeps
+// the same across retries of one event. This is the default.
+func EventIDKey(event Event) string {
+	return event.Metadata.EventID
+}
+
+// WebhookIDKey dedups on the X-Shopify-Webhook-Id header, which identifies a
+// single delivery to a single subscription.
+func WebhookIDKey(event Event) string {
+	return event.Metadata.WebhookID
+}
+
+// ContentHashKey dedups on a SHA-256 hash of the topic, shop domain and raw
+// body, so identical payloads are processed once regardless of headers.
+func ContentHashKey(event Event) string {
+	h := sha256.New()
+	h.Write([]byte(event.Metadata.Topic))
+	h.Write([]byte{0})
+	h.Write([]byte(event.Metadata.ShopDomain))
+	h.Write([]byte{0})
+	h.Write(event.RawBody)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// PayloadKey returns a DedupKeyFunc that dedups on top-level fields of the
+// JSON payload, scoped to the event's topic and shop domain. For example,
+// PayloadKey("id", "updated_at") treats two orders/updated deliveries of
+// the same order version as duplicates.
+//
+// The key is empty if the body is not a JSON object or any field is
+// missing or null.
+func PayloadKey(fields ...string) DedupKeyFunc {
+	return func(event Event) string {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(event.RawBody, &payload); err != nil {
+			return ""
+		}
+
+		parts := make([]string, 0, len(fields)+2)
+		parts = append(parts, string(event.Metadata.Topic), event.Metadata.ShopDomain)
+		for _, field := range fields {
+			raw, ok := payload[field]
+			if !ok || bytes.Equal(raw, []byte("null")) {
+				return ""
+			}
+			parts = append(parts, string(raw))
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// FirstKey returns a DedupKeyFunc that uses the first non-empty key from
+// fns, e.g. FirstKey(EventIDKey, ContentHashKey) to fall back to a content
+// hash when the event ID header is missing.
+func FirstKey(fns ...DedupKeyFunc) DedupKeyFunc {
+	return func(event Event) string {
+		for _, fn := range fns {
+			if key := fn(event); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
@@ -0,0 +1,109 @@
+package shopifywebhook
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPayloadKey(t *testing.T) {
+	key := PayloadKey("id", "updated_at")
+	event := func(body string) Event {
+		return Event{
+			Metadata: Metadata{Topic: TopicOrdersUpdate, ShopDomain: "a.myshopify.com"},
+			RawBody:  []byte(body),
+		}
+	}
+
+	a := key(event(`{"id":1,"updated_at":"2025-01-01T00:00:00Z","note":"x"}`))
+	b := key(event(`{"note":"y","updated_at":"2025-01-01T00:00:00Z","id":1}`))
+	if a == "" || a != b {
+		t.Fatalf("expected equal non-empty keys, got %q and %q", a, b)
+	}
+	if c := key(event(`{"id":1,"updated_at":"2025-01-02T00:00:00Z"}`)); c == a {
+		t.Fatal("expected a different key for a different version")
+	}
+
+	for _, body := range []string{`{"id":1}`, `{"id":1,"updated_at":null}`, `[1]`, `not json`} {
+		if k := key(event(body)); k != "" {
+			t.Fatalf("expected empty key for %s, got %q", body, k)
+		}
+	}
+}
+
+func TestContentHashKey(t *testing.T) {
+	a := Event{Metadata: Metadata{Topic: TopicOrdersCreate, ShopDomain: "a.myshopify.com"}, RawBody: []byte(`{"id":1}`)}
+	b := a
+	b.Metadata.ShopDomain = "b.myshopify.com"
+
+	if ContentHashKey(a) != ContentHashKey(a) {
+		t.Fatal("expected hash to be stable")
+	}
+	if ContentHashKey(a) == ContentHashKey(b) {
+		t.Fatal("expected different shops to hash differently")
+	}
+}
+
+func TestFirstKey(t *testing.T) {
+	key := FirstKey(EventIDKey, WebhookIDKey)
+	if got := key(Event{Metadata: Metadata{EventID: "e", WebhookID: "w"}}); got != "e" {
+		t.Fatalf("expected event ID, got %q", got)
+	}
+	if got := key(Event{Metadata: Metadata{WebhookID: "w"}}); got != "w" {
+		t.Fatalf("expected webhook ID fallback, got %q", got)
+	}
+	if got := key(Event{}); got != "" {
+		t.Fatalf("expected empty key, got %q", got)
+	}
+}
+
+func TestHandler_EmptyDedupKeyIsNotDeduplicated(t *testing.T) {
+	secret := "test-secret"
+
+	var calls int
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls++
+		return nil
+	})
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	handler := Handler(secret, router, WithIdempotencyStore(store))
+
+	for range 3 {
+		req := signedRequest(secret, `{"id":1}`, TopicOrdersCreate)
+		req.Header.Del("X-Shopify-Event-Id")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if calls != 3 {
+		t.Fatalf("expected events without an ID to all be processed, got %d calls", calls)
+	}
+}
+
+func TestHandler_WithDedupKey(t *testing.T) {
+	secret := "test-secret"
+
+	var calls int
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls++
+		return nil
+	})
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	handler := Handler(secret, router, WithIdempotencyStore(store), WithDedupKey(PayloadKey("id")))
+
+	for i, body := range []string{`{"id":1}`, `{"id":1}`, `{"id":2}`} {
+		req := signedRequest(secret, body, TopicOrdersCreate)
+		req.Header.Set("X-Shopify-Event-Id", fmt.Sprintf("event-%d", i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected dedup on payload id, got %d calls", calls)
+	}
+}
@@ -0,0 +1,116 @@
+package shopifywebhook
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// delayQueue holds retries until they are due, then hands them back to the
+// WorkerPool's queue. A single goroutine and timer serve all pending
+// retries, so backing off never occupies a worker.
+type delayQueue struct {
+	mu      sync.Mutex
+	items   delayHeap
+	stopped bool
+	wake    chan struct{} // signals run that the earliest item changed
+	exited  chan struct{} // closed when run returns
+}
+
+type delayedWork struct {
+	work
+	due time.Time
+}
+
+func newDelayQueue() *delayQueue {
+	return &delayQueue{
+		wake:   make(chan struct{}, 1),
+		exited: make(chan struct{}),
+	}
+}
+
+// schedule queues w until due. It returns false if the queue has stopped,
+// in which case the caller must report w itself.
+func (q *delayQueue) schedule(w work, due time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return false
+	}
+	heap.Push(&q.items, delayedWork{work: w, due: due})
+	if q.items[0].due.Equal(due) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// run moves due items to out until ctx is cancelled, then stops the queue
+// and passes everything still waiting to abandon.
+func (q *delayQueue) run(ctx context.Context, out chan<- work, abandon func(work)) {
+	defer close(q.exited)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var due []work
+		for len(q.items) > 0 && !q.items[0].due.After(now) {
+			due = append(due, heap.Pop(&q.items).(delayedWork).work)
+		}
+		if len(q.items) > 0 {
+			timer.Reset(q.items[0].due.Sub(now))
+		} else {
+			timer.Stop()
+		}
+		q.mu.Unlock()
+
+		for _, w := range due {
+			// Wait for room rather than dropping a retry; workers never
+			// block on the delay queue, so room always opens up.
+			if ctx.Err() == nil {
+				select {
+				case out <- w:
+					continue
+				case <-ctx.Done():
+				}
+			}
+			abandon(w)
+		}
+
+		select {
+		case <-timer.C:
+		case <-q.wake:
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.stopped = true
+			items := q.items
+			q.items = nil
+			q.mu.Unlock()
+			for _, item := range items {
+				abandon(item.work)
+			}
+			return
+		}
+	}
+}
+
+// delayHeap is a min-heap of delayed work ordered by due time.
+type delayHeap []delayedWork
+
+func (h delayHeap) Len() int           { return len(h) }
+func (h delayHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h delayHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x any)        { *h = append(*h, x.(delayedWork)) }
+func (h *delayHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,552 @@
+package shopifywebhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurableQueue is an AsyncProcessor that writes each event to a write-ahead
+// log on disk before handing it to another processor, so events that
+// Handler has acknowledged to Shopify survive a crash or deploy.
+//
+// Submit appends the event to the current log segment and syncs it before
+// returning. Once the inner processor reports the event's final outcome,
+// it is acknowledged in the log. On startup, OpenDurableQueue replays every
+// unacknowledged event into the inner processor. Segments are deleted once
+// every event in them, and in all older segments, is acknowledged.
+//
+// Delivery is at-least-once: an event that finished processing just before
+// a crash may be replayed. Pair it with an idempotency store to suppress
+// the duplicate.
+//
+// Events that fail while the queue is shutting down are not acknowledged,
+// and are replayed on the next start.
+type DurableQueue struct {
+	dir     string
+	inner   TrackingProcessor
+	router  *Router
+	onError ErrorHandlerFunc
+	segSize int64
+
+	mu       sync.Mutex
+	closing  bool
+	active   segmentFile
+	activeID uint64
+	written  int64                // bytes in the active segment
+	nextSeq  uint64               // sequence number of the next event
+	pending  map[uint64]uint64    // unacknowledged event seq -> segment ID
+	segments map[uint64]int       // segment ID -> unacknowledged events
+	replay   chan struct{}        // limits replayed events in flight
+	backlog  map[uint64]walRecord // events recovered at startup, by seq
+}
+
+// DurableQueueOption configures a DurableQueue.
+type DurableQueueOption func(*DurableQueue)
+
+// WithSegmentSize sets the size at which the log rolls over to a new
+// segment. Smaller segments are reclaimed sooner. Default: 64 MiB.
+func WithSegmentSize(n int64) DurableQueueOption {
+	return func(q *DurableQueue) {
+		q.segSize = n
+	}
+}
+
+// WithDurableErrorHandler sets the handler for log failures, such as a
+// Submit whose event could not be written. Such an event is rejected, so
+// Handler answers 503 and Shopify redelivers it.
+func WithDurableErrorHandler(fn ErrorHandlerFunc) DurableQueueOption {
+	return func(q *DurableQueue) {
+		q.onError = fn
+	}
+}
+
+// WithReplayLimit sets how many recovered events are handed to the inner
+// processor at a time during startup replay, so a large backlog doesn't
+// overflow its queue. Default: 100.
+func WithReplayLimit(n int) DurableQueueOption {
+	return func(q *DurableQueue) {
+		q.replay = make(chan struct{}, max(n, 1))
+	}
+}
+
+// OpenDurableQueue opens or creates the log in dir and starts replaying
+// its unacknowledged events into inner, dispatching them to router.
+// Events submitted later are dispatched to the router passed to Submit.
+//
+// inner is typically a WorkerPool. The DurableQueue owns it: Shutdown
+// shuts it down.
+func OpenDurableQueue(dir string, inner TrackingProcessor, router *Router, opts ...DurableQueueOption) (*DurableQueue, error) {
+	q := &DurableQueue{
+		dir:      dir,
+		inner:    inner,
+		router:   router,
+		segSize:  64 << 20,
+		pending:  make(map[uint64]uint64),
+		segments: make(map[uint64]int),
+		replay:   make(chan struct{}, 100),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("shopifywebhook: open durable queue: %w", err)
+	}
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	err := q.rotate()
+	q.compact()
+	q.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	go q.replayBacklog()
+	return q, nil
+}
+
+// Submit writes the event to the log and hands it to the inner processor.
+// If the inner processor rejects the event, it is acknowledged in the log,
+// since the caller gets it back, and the error is returned.
+func (q *DurableQueue) Submit(event Event, router *Router) error {
+	return q.SubmitTracked(event, router, nil)
+}
+
+// SubmitTracked is like Submit, and calls done with the final outcome of
+// an accepted event as reported by the inner processor. It returns
+// ErrPoolClosed if the queue is shutting down, or the error if the event
+// could not be written to the log; either way the event is not processed.
+func (q *DurableQueue) SubmitTracked(event Event, router *Router, done CompletionFunc) error {
+	seq, err := q.append(event)
+	if err != nil {
+		q.report(event, err)
+		return err
+	}
+	err = q.inner.SubmitTracked(event, router, func(event Event, err error) {
+		q.settle(seq, err)
+		if done != nil {
+			done(event, err)
+		}
+	})
+	if err != nil {
+		q.settle(seq, nil)
+	}
+	return err
+}
+
+// Shutdown stops accepting events, shuts down the inner processor and
+// closes the log. Events still unacknowledged are replayed on the next
+// OpenDurableQueue.
+func (q *DurableQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closing = true
+	q.mu.Unlock()
+
+	err := q.inner.Shutdown(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active != nil {
+		if syncErr := q.active.Sync(); syncErr != nil && err == nil {
+			err = syncErr
+		}
+		q.active.Close()
+		q.active = nil
+	}
+	return err
+}
+
+func (q *DurableQueue) report(event Event, err error) {
+	if q.onError != nil {
+		q.onError(event, err)
+	}
+}
+
+// append writes event to the log, syncs it and returns its sequence number.
+func (q *DurableQueue) append(event Event) (uint64, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closing {
+		return 0, ErrPoolClosed
+	}
+	// active is nil if a previous write failure couldn't be repaired.
+	if q.active == nil || q.written >= q.segSize {
+		if err := q.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := q.nextSeq
+	start := q.written
+	if err := q.write(walRecord{kind: walEvent, seq: seq, body: body}); err != nil {
+		return 0, err
+	}
+	// The record is in the segment now, so its seq must never be reused,
+	// even if it is truncated away below.
+	q.nextSeq++
+	if err := q.active.Sync(); err != nil {
+		q.repair(start)
+		return 0, fmt.Errorf("shopifywebhook: sync durable queue: %w", err)
+	}
+	q.pending[seq] = q.activeID
+	q.segments[q.activeID]++
+	return seq, nil
+}
+
+// settle acknowledges seq in the log, unless processing failed while the
+// queue was shutting down.
+func (q *DurableQueue) settle(seq uint64, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil && q.closing {
+		return
+	}
+	seg, ok := q.pending[seq]
+	if !ok {
+		return
+	}
+
+	// Acknowledgements aren't synced: losing one only means the event is
+	// replayed, and the next event write syncs it anyway.
+	if q.active == nil && !q.closing {
+		_ = q.rotate()
+	}
+	if q.active != nil {
+		if werr := q.write(walRecord{kind: walAck, seq: seq}); werr != nil {
+			if q.onError != nil {
+				q.onError(Event{}, werr)
+			}
+			return
+		}
+	}
+	delete(q.pending, seq)
+	q.segments[seg]--
+	q.compact()
+}
+
+// write appends rec to the active segment. A failed write is repaired, so
+// that a torn record can't hide the records written after it from
+// recovery. Callers must hold q.mu.
+func (q *DurableQueue) write(rec walRecord) error {
+	buf := rec.encode()
+	if _, err := q.active.Write(buf); err != nil {
+		q.repair(q.written)
+		return fmt.Errorf("shopifywebhook: write durable queue: %w", err)
+	}
+	q.written += int64(len(buf))
+	return nil
+}
+
+// repair truncates the active segment back to size, dropping anything
+// written after it. If that fails, it abandons the segment for a new one:
+// recovery reads the abandoned segment up to its torn record, which
+// nothing follows. Callers must hold q.mu.
+func (q *DurableQueue) repair(size int64) {
+	if err := q.active.Truncate(size); err == nil {
+		if _, err := q.active.Seek(size, io.SeekStart); err == nil {
+			q.written = size
+			return
+		}
+	}
+	q.active.Close()
+	q.active = nil
+	if err := q.create(); err != nil {
+		q.report(Event{}, err)
+	}
+}
+
+// rotate syncs and closes the active segment, if any, and starts a new one.
+// Callers must hold q.mu.
+func (q *DurableQueue) rotate() error {
+	if q.active != nil {
+		if err := q.active.Sync(); err != nil {
+			return fmt.Errorf("shopifywebhook: sync durable queue: %w", err)
+		}
+		q.active.Close()
+		q.active = nil
+	}
+	return q.create()
+}
+
+// create starts a new active segment. Callers must hold q.mu.
+func (q *DurableQueue) create() error {
+	id := q.activeID + 1
+	f, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: create durable queue segment: %w", err)
+	}
+	// Sync the directory too, or the new segment's entry, and the events
+	// synced into it, could be lost in a crash.
+	if err := syncDir(q.dir); err != nil {
+		f.Close()
+		return fmt.Errorf("shopifywebhook: create durable queue segment: %w", err)
+	}
+	q.active, q.activeID, q.written = f, id, 0
+	q.segments[id] = 0
+	return nil
+}
+
+// compact deletes the oldest segments while they hold no unacknowledged
+// events. Segments are only deleted oldest first, so the acknowledgements
+// for events in a live segment are never lost. Callers must hold q.mu.
+func (q *DurableQueue) compact() {
+	ids := make([]uint64, 0, len(q.segments))
+	for id := range q.segments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if id == q.activeID || q.segments[id] > 0 {
+			return
+		}
+		if err := os.Remove(q.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		delete(q.segments, id)
+	}
+}
+
+// segmentFile is the part of *os.File that the active segment, and the
+// FileStore log, are written through.
+type segmentFile interface {
+	io.Writer
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+func (q *DurableQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.wal", id))
+}
+
+// syncDir flushes dir's entries to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// recover reads every segment in order, rebuilding the unacknowledged
+// events. A torn record at the end of the newest segment is expected after
+// a crash mid-write and is truncated away; other damage is reported to the
+// error handler.
+func (q *DurableQueue) recover() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: open durable queue: %w", err)
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".wal")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	q.backlog = make(map[uint64]walRecord)
+	for i, id := range ids {
+		q.segments[id] = 0
+		q.activeID = id
+		if err := q.readSegment(id, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+	for seq, rec := range q.backlog {
+		q.pending[seq] = rec.segment
+		q.segments[rec.segment]++
+	}
+	return nil
+}
+
+// readSegment reads the records of segment id. A record whose checksum
+// doesn't match is reported and skipped; a record whose length can't be
+// trusted ends the segment, as the records after it can't be found.
+func (q *DurableQueue) readSegment(id uint64, newest bool) error {
+	path := q.segmentPath(id)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: read durable queue: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var off int64 // end of the last whole record
+	for {
+		rec, size, err := readWALRecord(r)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF && newest:
+			// Torn by a crash mid-write: drop the partial record, so it
+			// isn't mistaken for damage once a newer segment exists.
+			if err := os.Truncate(path, off); err != nil {
+				return fmt.Errorf("shopifywebhook: read durable queue: %w", err)
+			}
+			return nil
+		case errors.Is(err, errWALChecksum):
+			q.report(Event{}, fmt.Errorf("shopifywebhook: durable queue segment %d at offset %d: %w", id, off, err))
+			off += size
+			continue
+		case err != nil:
+			q.report(Event{}, fmt.Errorf("shopifywebhook: durable queue segment %d at offset %d: %w; skipping the rest of the segment", id, off, err))
+			return nil
+		}
+		off += size
+
+		switch rec.kind {
+		case walEvent:
+			rec.segment = id
+			q.backlog[rec.seq] = rec
+		case walAck:
+			delete(q.backlog, rec.seq)
+		}
+		if rec.seq >= q.nextSeq {
+			q.nextSeq = rec.seq + 1
+		}
+	}
+}
+
+// replayBacklog hands the recovered events to the inner processor, at most
+// cap(q.replay) at a time. Events the inner processor rejects because its
+// queue is full are resubmitted shortly after.
+func (q *DurableQueue) replayBacklog() {
+	q.mu.Lock()
+	backlog := q.backlog
+	q.backlog = nil
+	q.mu.Unlock()
+
+	seqs := make([]uint64, 0, len(backlog))
+	for seq := range backlog {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	for _, seq := range seqs {
+		var event Event
+		if err := json.Unmarshal(backlog[seq].body, &event); err != nil {
+			q.report(event, fmt.Errorf("shopifywebhook: decode durable queue entry %d: %w", seq, err))
+			continue
+		}
+		q.replay <- struct{}{}
+		q.resubmit(seq, event)
+	}
+}
+
+func (q *DurableQueue) resubmit(seq uint64, event Event) {
+	q.mu.Lock()
+	closing := q.closing
+	q.mu.Unlock()
+	if closing {
+		<-q.replay
+		return
+	}
+
+	err := q.inner.SubmitTracked(event, q.router, func(event Event, err error) {
+		q.settle(seq, err)
+		<-q.replay
+	})
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		time.AfterFunc(10*time.Millisecond, func() { q.resubmit(seq, event) })
+	case err != nil:
+		// Left unacknowledged for the next start.
+		<-q.replay
+	}
+}
+
+// WAL record kinds.
+const (
+	walEvent byte = 'E'
+	walAck   byte = 'A'
+)
+
+// walRecord is one log entry. On disk it is a 4-byte length and 4-byte
+// CRC-32 of the payload, followed by the payload: the kind, an 8-byte
+// sequence number and, for events, the JSON-encoded Event.
+type walRecord struct {
+	kind    byte
+	seq     uint64
+	body    []byte
+	segment uint64 // set when read back
+}
+
+func (r walRecord) encode() []byte {
+	payload := make([]byte, 9+len(r.body))
+	payload[0] = r.kind
+	binary.BigEndian.PutUint64(payload[1:9], r.seq)
+	copy(payload[9:], r.body)
+
+	buf := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[8:], payload)
+	return buf
+}
+
+// Errors reading WAL records.
+var (
+	errWALLength   = errors.New("invalid record length")
+	errWALChecksum = errors.New("record checksum mismatch")
+)
+
+// readWALRecord reads the next record and returns it with its size on
+// disk. It returns io.EOF at the end of the log, io.ErrUnexpectedEOF for a
+// torn record, errWALLength if the header is corrupt, and errWALChecksum,
+// with the record's size, if the payload is.
+func readWALRecord(r io.Reader) (walRecord, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return walRecord{}, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[0:4])
+	if n < 9 || n > 1<<30 {
+		return walRecord{}, 0, errWALLength
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return walRecord{}, 0, err
+	}
+	size := int64(8 + n)
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return walRecord{}, size, errWALChecksum
+	}
+	return walRecord{
+		kind: payload[0],
+		seq:  binary.BigEndian.Uint64(payload[1:9]),
+		body: payload[9:],
+	}, size, nil
+}
@@ -0,0 +1,400 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collectingRouter records the event IDs it receives on orders/create.
+func collectingRouter() (*Router, func() []string) {
+	var mu sync.Mutex
+	var ids []string
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, event.Metadata.EventID)
+		return nil
+	})
+	return router, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ids...)
+	}
+}
+
+func orderEvent(id string) Event {
+	return Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate, EventID: id},
+		RawBody:  []byte(`{"id":1}`),
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDurableQueue_ReplaysAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	// The first process never finishes its events before "crashing".
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q1.Submit(orderEvent("a"), stuck)
+	q1.Submit(orderEvent("b"), stuck)
+
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == 2 })
+	_ = q2.Shutdown(context.Background())
+
+	if got := received(); got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected events replayed in order, got %v", got)
+	}
+}
+
+func TestDurableQueue_AcknowledgedEventsAreNotReplayed(t *testing.T) {
+	dir := t.TempDir()
+
+	router, received := collectingRouter()
+	q, err := OpenDurableQueue(dir, NewWorkerPool(2, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		q.Submit(orderEvent(fmt.Sprint(i)), router)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(received()); got != 5 {
+		t.Fatalf("expected 5 events processed, got %d", got)
+	}
+
+	router2, received2 := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = q2.Shutdown(context.Background())
+	if got := received2(); len(got) != 0 {
+		t.Fatalf("expected no replays, got %v", got)
+	}
+}
+
+func TestDurableQueue_CompactsAcknowledgedSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	router, received := collectingRouter()
+	q, err := OpenDurableQueue(dir, NewWorkerPool(1, 100), router, WithSegmentSize(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		q.Submit(orderEvent(fmt.Sprint(i)), router)
+	}
+	waitFor(t, func() bool { return len(received()) == 20 })
+	_ = q.Shutdown(context.Background())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if len(segments) != 1 {
+		t.Fatalf("expected only the active segment to remain, got %d", len(segments))
+	}
+}
+
+func TestDurableQueue_ToleratesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q1.Submit(orderEvent("a"), stuck)
+
+	// Simulate a crash in the middle of writing the next record.
+	q1.mu.Lock()
+	_, _ = q1.active.Write(walRecord{kind: walEvent, seq: 99, body: []byte(`{}`)}.encode()[:10])
+	q1.mu.Unlock()
+
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == 1 })
+	_ = q2.Shutdown(context.Background())
+
+	// The torn record was truncated away, so it isn't reported as damage
+	// now that a newer segment exists.
+	var reported atomic.Int32
+	q3, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), NewRouter(),
+		WithDurableErrorHandler(func(Event, error) { reported.Add(1) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = q3.Shutdown(context.Background())
+	if reported.Load() != 0 {
+		t.Fatalf("expected no damage reported after the torn record, got %d", reported.Load())
+	}
+}
+
+func TestDurableQueue_SkipsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		q1.Submit(orderEvent(id), stuck)
+	}
+
+	// Flip a byte in the first record's payload.
+	q1.mu.Lock()
+	path := q1.segmentPath(q1.activeID)
+	q1.mu.Unlock()
+	data, _ := os.ReadFile(path)
+	data[8+9+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported atomic.Int32
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router,
+		WithDurableErrorHandler(func(Event, error) { reported.Add(1) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == 2 })
+	_ = q2.Shutdown(context.Background())
+
+	if got := received(); got[0] != "b" || got[1] != "c" {
+		t.Fatalf("expected the records after the corrupt one to be replayed, got %v", got)
+	}
+	if reported.Load() != 1 {
+		t.Fatalf("expected the corrupt record to be reported once, got %d", reported.Load())
+	}
+}
+
+// faultyFile fails the next Write, after writing half of it, or the next
+// Sync, after the write went through.
+type faultyFile struct {
+	*os.File
+	failWrite, failSync bool
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if f.failWrite {
+		f.failWrite = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("disk full")
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errors.New("io error")
+	}
+	return f.File.Sync()
+}
+
+func TestDurableQueue_WriteFailuresDontLoseLaterEvents(t *testing.T) {
+	dir := t.TempDir()
+
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faulty := &faultyFile{File: q1.active.(*os.File)}
+	q1.mu.Lock()
+	q1.active = faulty
+	q1.mu.Unlock()
+
+	if err := q1.Submit(orderEvent("a"), stuck); err != nil {
+		t.Fatal(err)
+	}
+	faulty.failWrite = true
+	if err := q1.Submit(orderEvent("torn"), stuck); err == nil {
+		t.Fatal("expected the failed write to be returned")
+	}
+	faulty.failSync = true
+	if err := q1.Submit(orderEvent("unsynced"), stuck); err == nil {
+		t.Fatal("expected the failed sync to be returned")
+	}
+	if err := q1.Submit(orderEvent("b"), stuck); err != nil {
+		t.Fatal(err)
+	}
+
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == 2 })
+	time.Sleep(20 * time.Millisecond)
+	_ = q2.Shutdown(context.Background())
+
+	if got := received(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected only the persisted events to be replayed, got %v", got)
+	}
+}
+
+func TestDurableQueue_ReplayWaitsForRoom(t *testing.T) {
+	dir := t.TempDir()
+
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 100), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 30 {
+		q1.Submit(orderEvent(fmt.Sprint(i)), stuck)
+	}
+
+	// A tiny queue can't take the backlog at once.
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 2), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == 30 })
+	_ = q2.Shutdown(context.Background())
+}
+
+func TestDurableQueue_RejectedEventsAreNotReplayed(t *testing.T) {
+	dir := t.TempDir()
+
+	stuck := NewRouter()
+	block := make(chan struct{})
+	defer close(block)
+	stuck.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	q1, err := OpenDurableQueue(dir, NewWorkerPool(1, 1), stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := 0
+	for i := range 5 {
+		if err := q1.Submit(orderEvent(fmt.Sprint(i)), stuck); err == nil {
+			accepted++
+		} else if !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("expected ErrQueueFull, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if accepted == 5 {
+		t.Fatal("expected some events to be rejected")
+	}
+
+	// Rejected events went back to the caller, so only accepted ones are
+	// replayed.
+	router, received := collectingRouter()
+	q2, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(received()) == accepted })
+	time.Sleep(50 * time.Millisecond)
+	_ = q2.Shutdown(context.Background())
+	if got := len(received()); got != accepted {
+		t.Fatalf("expected %d replayed events, got %d", accepted, got)
+	}
+}
+
+func TestDurableQueue_HandlerPersistsBeforeResponding(t *testing.T) {
+	dir := t.TempDir()
+
+	router, _ := collectingRouter()
+	q, err := OpenDurableQueue(dir, NewWorkerPool(1, 10), router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Shutdown(context.Background())
+
+	var size int64
+	handler := Handler("test-secret", router, WithAsyncProcessor(q))
+	rec := &statusRecorder{onWrite: func() {
+		info, _ := os.Stat(q.segmentPath(q.activeID))
+		size = info.Size()
+	}}
+	handler.ServeHTTP(rec, signedRequest("test-secret", `{"id":1}`, TopicOrdersCreate))
+
+	if size == 0 {
+		t.Fatal("expected the event to be in the log before the response was written")
+	}
+}
+
+// statusRecorder calls onWrite when the status is written.
+type statusRecorder struct {
+	header  http.Header
+	onWrite func()
+}
+
+func (r *statusRecorder) Header() http.Header {
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	return r.header
+}
+func (r *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *statusRecorder) WriteHeader(int)             { r.onWrite() }
@@ -1,22 +1,200 @@
 package shopifywebhook
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	// ErrInvalidSignature is returned when HMAC-SHA256 verification fails.
 	ErrInvalidSignature = errors.New("shopifywebhook: invalid HMAC signature")
 
+	// ErrNoSecrets is returned when a SecretProvider supplies no unexpired secrets.
+	ErrNoSecrets = errors.New("shopifywebhook: no valid secrets to verify against")
+
 	// ErrMissingSignature is returned when the X-Shopify-Hmac-Sha256 header is absent.
 	ErrMissingSignature = errors.New("shopifywebhook: missing X-Shopify-Hmac-Sha256 header")
 
+	// ErrUnknownApp is returned when an AppResolver cannot match a request
+	// to a configured app.
+	ErrUnknownApp = errors.New("shopifywebhook: no app configured for request")
+
+	// ErrBodyTooLarge is returned when a request body exceeds the configured
+	// maximum size. Middleware and Handler respond 413 Request Entity Too Large.
+	ErrBodyTooLarge = errors.New("shopifywebhook: request body too large")
+
 	// ErrMissingTopic is returned when the X-Shopify-Topic header is absent.
 	ErrMissingTopic = errors.New("shopifywebhook: missing X-Shopify-Topic header")
 
+	// ErrInvalidTriggeredAt is returned by ReplayWindow.Check when the
+	// X-Shopify-Triggered-At header is missing or not an RFC 3339 timestamp.
+	ErrInvalidTriggeredAt = errors.New("shopifywebhook: missing or invalid X-Shopify-Triggered-At header")
+
+	// ErrStaleEvent is returned by ReplayWindow.Check when an event was
+	// triggered longer ago than the window allows.
+	ErrStaleEvent = errors.New("shopifywebhook: event is older than the replay window")
+
+	// ErrFutureEvent is returned by ReplayWindow.Check when an event's
+	// triggered-at time is further in the future than the clock skew allows.
+	ErrFutureEvent = errors.New("shopifywebhook: event triggered-at is in the future")
+
 	// ErrUnhandledTopic is returned when no handler is registered for a topic
 	// and no fallback handler is set.
 	ErrUnhandledTopic = errors.New("shopifywebhook: unhandled topic")
 
-	// ErrQueueFull is returned when the async worker pool's queue is full
-	// and the event is dropped.
-	ErrQueueFull = errors.New("shopifywebhook: worker pool queue full, event dropped")
+	// ErrQueueFull is returned when an async processor's queue is full. The
+	// event is rejected rather than queued, so Handler answers 503 and
+	// Shopify redelivers it later.
+	ErrQueueFull = errors.New("shopifywebhook: worker pool queue full, event rejected")
+
+	// ErrPoolClosed is returned when an event is submitted to a worker pool
+	// that is shutting down. The event is rejected, as for ErrQueueFull.
+	ErrPoolClosed = errors.New("shopifywebhook: worker pool shut down, event rejected")
+
+	// ErrAlreadyClaimed is returned by ClaimStore.Claim when another
+	// delivery of the event holds an unexpired claim on it. Handler answers
+	// 409 Conflict, so Shopify redelivers the event later in case that
+	// delivery fails and releases its claim.
+	ErrAlreadyClaimed = errors.New("shopifywebhook: event is claimed by another delivery")
+
+	// ErrNoPayloadType is returned by Event.Payload when the topic has no
+	// built-in payload type.
+	ErrNoPayloadType = errors.New("shopifywebhook: no payload type for topic")
 )
+
+// DecodeError is returned when a webhook payload cannot be decoded into the
+// Go type expected by a typed handler. It is reported through the Router's
+// error handler like any other handler error; use errors.As to detect it.
+type DecodeError struct {
+	Topic Topic
+	Type  string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("shopifywebhook: decoding %s payload into %s: %v", e.Topic, e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned when a handler panics during dispatch. The panic
+// is recovered and reported through the Router's error handler and the
+// WorkerPool's retry logic like any other handler error.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+
+	// Stack is the goroutine stack trace captured when the panic was recovered.
+	Stack []byte
+
+	// Event is the event being dispatched when the handler panicked.
+	Event Event
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("shopifywebhook: handler for %s panicked: %v", e.Event.Metadata.Topic, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// SubscriberError is the failure of a single subscriber in a fan-out
+// dispatch.
+type SubscriberError struct {
+	// Index is the subscriber's position in registration order.
+	Index int
+
+	// Name is the name given to Subscribe, or "#<Index>" if none was given.
+	Name string
+
+	Err error
+}
+
+func (e *SubscriberError) Error() string {
+	return fmt.Sprintf("subscriber %s: %v", e.Name, e.Err)
+}
+
+func (e *SubscriberError) Unwrap() error {
+	return e.Err
+}
+
+// FanoutError aggregates the subscriber failures from dispatching an event
+// to a topic with several subscribers. Like errors.Join, it matches
+// errors.Is and errors.As against every wrapped error.
+//
+// Router.RetryFailed uses it to re-run only the failed subscribers.
+type FanoutError struct {
+	Errors []*SubscriberError
+}
+
+func (e *FanoutError) Error() string {
+	var b strings.Builder
+	b.WriteString("shopifywebhook: ")
+	for i, err := range e.Errors {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *FanoutError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// failed returns the indices of the failed subscribers.
+func (e *FanoutError) failed() []int {
+	indices := make([]int, len(e.Errors))
+	for i, err := range e.Errors {
+		indices[i] = err.Index
+	}
+	return indices
+}
+
+// RedisError is an error reply from a Redis server.
+type RedisError struct {
+	Message string
+}
+
+func (e *RedisError) Error() string {
+	return "shopifywebhook: redis: " + e.Message
+}
+
+// PermanentError marks an error that retrying cannot fix. Create one with
+// Permanent.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// RetryableError marks an error as worth retrying, overriding the default
+// classification of IsRetryable. Create one with Retryable.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,73 @@
+package shopifywebhook
+
+import (
+	"context"
+	"sync"
+)
+
+// FanoutMode selects how multiple subscribers to a topic are run.
+type FanoutMode int
+
+const (
+	fanoutOff FanoutMode = iota
+
+	// FanoutSequential runs subscribers one after another in registration
+	// order. A failing subscriber does not stop the ones after it.
+	FanoutSequential
+
+	// FanoutConcurrent runs all subscribers at once, each in its own
+	// goroutine, and waits for every one to finish.
+	FanoutConcurrent
+)
+
+// runFanout runs subs (or only the indices listed in only) and collects
+// their failures into a *FanoutError.
+func runFanout(ctx context.Context, event Event, subs []subscriber, middleware []DispatchMiddleware, mode FanoutMode, only []int) error {
+	indices := only
+	if indices == nil {
+		indices = make([]int, len(subs))
+		for i := range subs {
+			indices[i] = i
+		}
+	}
+
+	errs := make([]error, len(indices))
+	run := func(slot, idx int) {
+		if idx < 0 || idx >= len(subs) {
+			return
+		}
+		errs[slot] = callHandler(ctx, chain(middleware, subs[idx].handler), event)
+	}
+
+	if mode == FanoutConcurrent {
+		var wg sync.WaitGroup
+		wg.Add(len(indices))
+		for slot, idx := range indices {
+			go func() {
+				defer wg.Done()
+				run(slot, idx)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for slot, idx := range indices {
+			run(slot, idx)
+		}
+	}
+
+	var fanoutErr FanoutError
+	for slot, err := range errs {
+		if err != nil {
+			idx := indices[slot]
+			fanoutErr.Errors = append(fanoutErr.Errors, &SubscriberError{
+				Index: idx,
+				Name:  subs[idx].name,
+				Err:   err,
+			})
+		}
+	}
+	if len(fanoutErr.Errors) == 0 {
+		return nil
+	}
+	return &fanoutErr
+}
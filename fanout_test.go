@@ -0,0 +1,160 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanout_Sequential_AllSubscribersRun(t *testing.T) {
+	router := NewRouter(WithFanout(FanoutSequential))
+
+	var calls []string
+	router.Subscribe(TopicOrdersPaid, "billing", func(ctx context.Context, event Event) error {
+		calls = append(calls, "billing")
+		return nil
+	})
+	router.Subscribe(TopicOrdersPaid, "analytics", func(ctx context.Context, event Event) error {
+		calls = append(calls, "analytics")
+		return nil
+	})
+	router.Handle(TopicOrdersPaid, func(event Event) error {
+		calls = append(calls, "search")
+		return nil
+	})
+
+	if err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersPaid}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(calls) != 3 || calls[0] != "billing" || calls[1] != "analytics" || calls[2] != "search" {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+}
+
+func TestFanout_AggregatesErrors(t *testing.T) {
+	errBilling := errors.New("billing down")
+	errSearch := errors.New("search down")
+
+	var captured error
+	router := NewRouter(
+		WithFanout(FanoutConcurrent),
+		WithErrorHandler(func(event Event, err error) { captured = err }),
+	)
+	router.Subscribe(TopicOrdersPaid, "billing", func(ctx context.Context, event Event) error { return errBilling })
+	router.Subscribe(TopicOrdersPaid, "analytics", func(ctx context.Context, event Event) error { return nil })
+	router.Subscribe(TopicOrdersPaid, "search", func(ctx context.Context, event Event) error { return errSearch })
+
+	err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersPaid}})
+
+	var fanoutErr *FanoutError
+	if !errors.As(err, &fanoutErr) {
+		t.Fatalf("expected *FanoutError, got: %v", err)
+	}
+	if len(fanoutErr.Errors) != 2 {
+		t.Fatalf("expected 2 subscriber errors, got %d", len(fanoutErr.Errors))
+	}
+	if !errors.Is(err, errBilling) || !errors.Is(err, errSearch) {
+		t.Fatalf("expected aggregated error to match both causes: %v", err)
+	}
+	if fanoutErr.Errors[0].Name != "billing" || fanoutErr.Errors[1].Name != "search" {
+		t.Fatalf("unexpected subscriber names: %v", err)
+	}
+	if captured != err {
+		t.Fatal("expected error handler to be called once with the aggregate")
+	}
+}
+
+func TestFanout_Concurrent_RunsInParallel(t *testing.T) {
+	router := NewRouter(WithFanout(FanoutConcurrent))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for range 2 {
+		router.HandleContext(TopicOrdersPaid, func(ctx context.Context, event Event) error {
+			wg.Done()
+			wg.Wait() // Deadlocks unless both subscribers run at once.
+			return nil
+		})
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersPaid}}) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscribers did not run concurrently")
+	}
+}
+
+func TestFanout_DisabledByDefault(t *testing.T) {
+	router := NewRouter()
+	router.Subscribe(TopicOrdersPaid, "billing", func(ctx context.Context, event Event) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on second subscriber without WithFanout")
+		}
+	}()
+	router.Subscribe(TopicOrdersPaid, "analytics", func(ctx context.Context, event Event) error { return nil })
+}
+
+func TestRouter_RetryFailed_OnlyRerunsFailedSubscribers(t *testing.T) {
+	router := NewRouter(WithFanout(FanoutSequential))
+
+	var okCalls, failCalls int
+	router.Subscribe(TopicOrdersPaid, "ok", func(ctx context.Context, event Event) error {
+		okCalls++
+		return nil
+	})
+	router.Subscribe(TopicOrdersPaid, "flaky", func(ctx context.Context, event Event) error {
+		failCalls++
+		if failCalls == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	event := Event{Metadata: Metadata{Topic: TopicOrdersPaid}}
+	err := router.Dispatch(event)
+	if err == nil {
+		t.Fatal("expected first dispatch to fail")
+	}
+
+	if err := router.RetryFailed(context.Background(), event, err); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if okCalls != 1 || failCalls != 2 {
+		t.Fatalf("expected ok=1 flaky=2, got ok=%d flaky=%d", okCalls, failCalls)
+	}
+}
+
+func TestWorkerPool_FanoutRetriesOnlyFailedSubscriber(t *testing.T) {
+	router := NewRouter(WithFanout(FanoutSequential))
+
+	var okCalls, flakyCalls atomic.Int32
+	router.Subscribe(TopicOrdersPaid, "ok", func(ctx context.Context, event Event) error {
+		okCalls.Add(1)
+		return nil
+	})
+	router.Subscribe(TopicOrdersPaid, "flaky", func(ctx context.Context, event Event) error {
+		if flakyCalls.Add(1) < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 10, WithMaxRetries(3), WithRetryBaseDelay(time.Millisecond))
+	pool.Submit(Event{Metadata: Metadata{Topic: TopicOrdersPaid}, RawBody: []byte(`{}`)}, router)
+	_ = pool.Shutdown(context.Background())
+
+	if okCalls.Load() != 1 || flakyCalls.Load() != 3 {
+		t.Fatalf("expected ok=1 flaky=3, got ok=%d flaky=%d", okCalls.Load(), flakyCalls.Load())
+	}
+}
@@ -0,0 +1,312 @@
+package shopifywebhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// minCompactRecords is the log size below which FileStore never compacts.
+const minCompactRecords = 1024
+
+// FileStore is an IdempotencyStore and ClaimStore that persists completed
+// event IDs to an append-only log file, so deduplication survives restarts.
+// Use it for single-instance deployments where MemoryStore would forget
+// everything just as Shopify's backlog of retries arrives.
+//
+// Each completed event is appended to the log and synced to disk before
+// Store or Complete returns. Expired entries are evicted periodically, and
+// the log is rewritten without them once it is mostly dead records.
+// In-flight claims are held in memory only; a claim lost to a crash is
+// simply claimable again on restart.
+//
+// A FileStore is safe for concurrent use by multiple goroutines, but the
+// file must not be shared between processes.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	file    segmentFile
+	size    int64 // end of the last whole record in file
+	torn    bool  // file may end in a partial record past size
+	entries map[string]memoryEntry
+	records int // records in the log file, live or not
+	ttl     time.Duration
+	done    chan struct{}
+}
+
+// fileRecord is one line of the FileStore log.
+type fileRecord struct {
+	ID      string `json:"id"`
+	Expires int64  `json:"exp"` // Unix nanoseconds
+}
+
+// OpenFileStore opens or creates the log at path and loads its unexpired
+// entries. Entries stored from now on expire after ttl.
+//
+// Typical TTL: 24 hours, as for NewMemoryStore.
+func OpenFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	s := &FileStore{
+		path:    path,
+		entries: make(map[string]memoryEntry),
+		ttl:     ttl,
+		done:    make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	go s.cleanup(ttl / 2)
+	return s, nil
+}
+
+// load reads the log into memory. Malformed lines are skipped, and a final
+// line without its newline, torn by a crash mid-write, is truncated away so
+// the next record doesn't get appended onto it.
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: open file store: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	r := bufio.NewReader(f)
+	var size int64 // bytes up to the end of the last complete line
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := os.Truncate(s.path, size); err != nil {
+					return fmt.Errorf("shopifywebhook: repair file store: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("shopifywebhook: read file store: %w", err)
+		}
+		size += int64(len(line))
+
+		var rec fileRecord
+		if err := json.Unmarshal(line, &rec); err != nil || rec.ID == "" {
+			continue
+		}
+		s.records++
+		expires := time.Unix(0, rec.Expires)
+		if now.Before(expires) {
+			s.entries[rec.ID] = memoryEntry{expires: expires, completed: true}
+		}
+	}
+}
+
+// Exists checks if the event ID has been completed within the TTL window.
+// Events that are only claimed are not reported as existing.
+func (s *FileStore) Exists(_ context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[eventID]
+	return ok && e.completed && time.Now().Before(e.expires), nil
+}
+
+// Store records an event ID as completed and persists it to the log.
+func (s *FileStore) Store(_ context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(s.ttl)
+	if err := s.append(fileRecord{ID: eventID, Expires: expires.UnixNano()}); err != nil {
+		return err
+	}
+	s.entries[eventID] = memoryEntry{expires: expires, completed: true}
+	return nil
+}
+
+// Claim atomically reserves the event ID for lease. It fails if the event
+// was completed within the TTL, or with ErrAlreadyClaimed if it is claimed
+// under an unexpired lease.
+func (s *FileStore) Claim(_ context.Context, eventID string, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.entries[eventID]; ok && now.Before(e.expires) {
+		if !e.completed {
+			return false, ErrAlreadyClaimed
+		}
+		return false, nil
+	}
+	s.entries[eventID] = memoryEntry{expires: now.Add(lease)}
+	return true, nil
+}
+
+// Complete marks the event ID as processed for the store's TTL.
+func (s *FileStore) Complete(ctx context.Context, eventID string) error {
+	return s.Store(ctx, eventID)
+}
+
+// Release drops an uncompleted claim on the event ID.
+func (s *FileStore) Release(_ context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[eventID]; ok && !e.completed {
+		delete(s.entries, eventID)
+	}
+	return nil
+}
+
+// Close stops the background cleanup goroutine and closes the log file.
+func (s *FileStore) Close() error {
+	close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes rec to the log and syncs it. If either fails, the partial
+// record is truncated away, so the next one isn't appended onto it.
+// Callers must hold s.mu.
+func (s *FileStore) append(rec fileRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if s.torn {
+		// Truncating failed last time: end the partial record first, so
+		// it is skipped as malformed on load.
+		line = append([]byte{'\n'}, line...)
+	}
+	if _, err := s.file.Write(line); err != nil {
+		s.repair()
+		return fmt.Errorf("shopifywebhook: write file store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.repair()
+		return fmt.Errorf("shopifywebhook: sync file store: %w", err)
+	}
+	if s.torn {
+		size, err := s.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil // stay torn: the next record starts a new line too
+		}
+		s.size, s.torn = size, false
+	} else {
+		s.size += int64(len(line))
+	}
+	s.records++
+	return nil
+}
+
+// repair truncates the log back to its last whole record after a failed
+// append. Callers must hold s.mu.
+func (s *FileStore) repair() {
+	if s.torn || s.file.Truncate(s.size) != nil {
+		s.torn = true
+	}
+}
+
+// compact rewrites the log with only the live completed entries if most of
+// its records are dead, then (re)opens it for appending. Callers must hold
+// s.mu, or have exclusive access to s.
+func (s *FileStore) compact() error {
+	live := 0
+	for _, e := range s.entries {
+		if e.completed {
+			live++
+		}
+	}
+	if s.file != nil && (s.records < minCompactRecords || s.records < 2*live) {
+		return nil
+	}
+
+	if s.records > live {
+		if err := s.rewrite(); err != nil {
+			return err
+		}
+		s.records = live
+		s.torn = false // the partial record, if any, was dropped
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: open file store: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("shopifywebhook: open file store: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// rewrite atomically replaces the log with the live completed entries.
+func (s *FileStore) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for id, e := range s.entries {
+		if !e.completed {
+			continue
+		}
+		if err := enc.Encode(fileRecord{ID: id, Expires: e.expires.UnixNano()}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("shopifywebhook: compact file store: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			now := time.Now()
+			for id, e := range s.entries {
+				if now.After(e.expires) {
+					delete(s.entries, id)
+				}
+			}
+			// A failed compaction leaves the current log in place; it is
+			// retried on the next tick.
+			_ = s.compact()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package shopifywebhook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Store(ctx, "evt-1")
+	_, _ = store.Claim(ctx, "evt-2", time.Minute) // claims are not persisted
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if ok, _ := store.Exists(ctx, "evt-1"); !ok {
+		t.Fatal("expected evt-1 to survive a restart")
+	}
+	if ok, _ := store.Claim(ctx, "evt-2", time.Minute); !ok {
+		t.Fatal("expected an unfinished claim to be claimable after a restart")
+	}
+}
+
+func TestFileStore_TTLExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Store(ctx, "evt")
+	_ = store.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if ok, _ := store.Exists(ctx, "evt"); ok {
+		t.Fatal("expected expired event to be gone after a restart")
+	}
+}
+
+func TestFileStore_SkipsTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Store(ctx, "evt")
+	_ = store.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = f.WriteString(`{"id":"partial","ex`)
+	_ = f.Close()
+
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Exists(ctx, "evt"); !ok {
+		t.Fatal("expected records before the truncated one to load")
+	}
+
+	// A record stored after the crash must not be merged into the torn line.
+	_ = store.Store(ctx, "after")
+	_ = store.Close()
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if ok, _ := store.Exists(ctx, "after"); !ok {
+		t.Fatal("expected the record stored after the torn one to survive")
+	}
+}
+
+func TestFileStore_SkipsOversizedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	garbage := strings.Repeat("x", 200<<10) + "\n"
+	if err := os.WriteFile(path, []byte(garbage), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("expected an oversized corrupt line to be skipped: %v", err)
+	}
+	defer store.Close()
+	if err := store.Store(ctx, "evt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStore_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-storing the same ID leaves dead records behind.
+	for range 2 * minCompactRecords {
+		_ = store.Store(ctx, "evt")
+	}
+	before, _ := os.Stat(path)
+
+	store.mu.Lock()
+	err = store.compact()
+	store.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("expected compaction to shrink the log: %d -> %d bytes", before.Size(), after.Size())
+	}
+
+	// The store keeps appending to the compacted log.
+	_ = store.Store(ctx, "evt-2")
+	_ = store.Close()
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []string{"evt", "evt-2"} {
+		if ok, _ := store.Exists(ctx, id); !ok {
+			t.Fatalf("expected %s to survive compaction", id)
+		}
+	}
+}
+
+func TestFileStore_ConcurrentClaims(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "dedup.log"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var won atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.Background()
+			if ok, _ := store.Claim(ctx, "evt", time.Minute); ok {
+				won.Add(1)
+				_ = store.Complete(ctx, "evt")
+			}
+			_ = store.Store(ctx, fmt.Sprintf("other-%d", i))
+		}()
+	}
+	wg.Wait()
+
+	if got := won.Load(); got != 1 {
+		t.Fatalf("expected exactly 1 claim to win, got %d", got)
+	}
+}
+
+func TestFileStore_RepairsFailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Store(ctx, "before")
+
+	file := &faultyFile{File: store.file.(*os.File), failWrite: true}
+	store.file = file
+	if err := store.Store(ctx, "failed-write"); err == nil {
+		t.Fatal("expected the failed write to be reported")
+	}
+	file.failSync = true
+	if err := store.Store(ctx, "failed-sync"); err == nil {
+		t.Fatal("expected the failed sync to be reported")
+	}
+	_ = store.Store(ctx, "after")
+	_ = store.Close()
+
+	store, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []string{"before", "after"} {
+		if ok, _ := store.Exists(ctx, id); !ok {
+			t.Fatalf("expected %s to survive the failed appends", id)
+		}
+	}
+	if ok, _ := store.Exists(ctx, "failed-sync"); ok {
+		t.Fatal("expected the record whose sync failed to be dropped")
+	}
+}
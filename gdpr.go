@@ -1,5 +1,7 @@
 package shopifywebhook
 
+import "context"
+
 // CustomerDataRequest is the payload for customers/data_request webhooks.
 // Shopify sends this when a customer requests their data under GDPR/CCPA.
 type CustomerDataRequest struct {
@@ -60,27 +62,15 @@ func RegisterGDPR(router *Router, handlers GDPRHandlers) {
 		panic("shopifywebhook: GDPRHandlers.OnShopRedact must not be nil")
 	}
 
-	router.Handle(TopicCustomersDataRequest, func(event Event) error {
-		var payload CustomerDataRequest
-		if err := event.Unmarshal(&payload); err != nil {
-			return err
-		}
+	HandleTyped(router, TopicCustomersDataRequest, func(_ context.Context, event Event, payload CustomerDataRequest) error {
 		return handlers.OnCustomerDataRequest(event, payload)
 	})
 
-	router.Handle(TopicCustomersRedact, func(event Event) error {
-		var payload CustomerRedact
-		if err := event.Unmarshal(&payload); err != nil {
-			return err
-		}
+	HandleTyped(router, TopicCustomersRedact, func(_ context.Context, event Event, payload CustomerRedact) error {
 		return handlers.OnCustomerRedact(event, payload)
 	})
 
-	router.Handle(TopicShopRedact, func(event Event) error {
-		var payload ShopRedact
-		if err := event.Unmarshal(&payload); err != nil {
-			return err
-		}
+	HandleTyped(router, TopicShopRedact, func(_ context.Context, event Event, payload ShopRedact) error {
 		return handlers.OnShopRedact(event, payload)
 	})
 }
@@ -0,0 +1,6 @@
+// Package sqlitetest runs SQLStore against a real SQLite database. It is a
+// separate module so the root module stays free of third-party
+// dependencies; run it with
+//
+//	cd internal/sqlitetest && go test ./...
+package sqlitetest
@@ -0,0 +1,153 @@
+package sqlitetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	shopifywebhook "github.com/hseinmoussa/shopify-webhook-go"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "events.db") + "?_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLStore_ClaimLifecycle(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	store, err := shopifywebhook.NewSQLStore(ctx, db, shopifywebhook.DialectSQLite, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ok, err := store.Claim(ctx, "evt", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected first claim to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := store.Claim(ctx, "evt", time.Minute); ok || !errors.Is(err, shopifywebhook.ErrAlreadyClaimed) {
+		t.Fatalf("expected concurrent claim to fail with ErrAlreadyClaimed, got %v, %v", ok, err)
+	}
+	if exists, err := store.Exists(ctx, "evt"); err != nil || exists {
+		t.Fatalf("expected a claimed event not to exist yet, got %v, %v", exists, err)
+	}
+
+	if err := store.Release(ctx, "evt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Claim(ctx, "evt", time.Minute); err != nil || !ok {
+		t.Fatalf("expected claim to succeed after release, got %v, %v", ok, err)
+	}
+
+	if err := store.Complete(ctx, "evt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists(ctx, "evt"); err != nil || !exists {
+		t.Fatalf("expected completed event to exist, got %v, %v", exists, err)
+	}
+	if ok, err := store.Claim(ctx, "evt", time.Minute); err != nil || ok {
+		t.Fatalf("expected claim on a completed event to fail, got %v, %v", ok, err)
+	}
+	if err := store.Release(ctx, "evt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := store.Exists(ctx, "evt"); !exists {
+		t.Fatal("expected release not to drop a completed event")
+	}
+}
+
+func TestSQLStore_ExpiryAndCleanup(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	store, err := shopifywebhook.NewSQLStore(ctx, db, shopifywebhook.DialectSQLite, 50*time.Millisecond,
+		shopifywebhook.WithSQLCleanupInterval(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	_ = store.Store(ctx, "done")
+	_, _ = store.Claim(ctx, "claimed", 50*time.Millisecond)
+	_ = store.Store(ctx, "other")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if exists, _ := store.Exists(ctx, "done"); exists {
+		t.Fatal("expected completed event to expire")
+	}
+	if ok, err := store.Claim(ctx, "claimed", time.Minute); err != nil || !ok {
+		t.Fatalf("expected expired claim to be claimable, got %v, %v", ok, err)
+	}
+
+	n, err := store.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 expired rows removed, got %d", n)
+	}
+	var live int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + shopifywebhook.DefaultSQLTable + ` WHERE event_id = 'claimed'`).Scan(&live); err != nil {
+		t.Fatal(err)
+	}
+	if live != 1 {
+		t.Fatal("expected live claim to survive cleanup")
+	}
+}
+
+func TestSQLStore_ExistingSchema(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	for range 2 {
+		store, err := shopifywebhook.NewSQLStore(ctx, db, shopifywebhook.DialectSQLite, time.Hour)
+		if err != nil {
+			t.Fatalf("expected the schema to be created idempotently: %v", err)
+		}
+		store.Close()
+	}
+}
+
+func TestSQLStore_ConcurrentClaims(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	store, err := shopifywebhook.NewSQLStore(ctx, db, shopifywebhook.DialectSQLite, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var won atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.Claim(ctx, "evt", time.Minute)
+			if err != nil && !errors.Is(err, shopifywebhook.ErrAlreadyClaimed) {
+				t.Error(err)
+			}
+			if ok {
+				won.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := won.Load(); got != 1 {
+		t.Fatalf("expected exactly one claim to win, got %d", got)
+	}
+}
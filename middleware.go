@@ -3,8 +3,11 @@ package shopifywebhook
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type contextKey int
@@ -28,15 +31,25 @@ func EventFromContext(ctx context.Context) (Event, bool) {
 //  4. Stores the Event in the request context (retrieve with EventFromContext)
 //  5. Replaces the request body so downstream handlers can still read it
 //
-// On verification failure, responds with 401 Unauthorized.
+// On verification failure, responds with 401 Unauthorized, or 413 Request
+// Entity Too Large if the body exceeds the size limit.
 func Middleware(secret string, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return MiddlewareWithSecrets(StaticSecret(secret), opts...)
+}
+
+// MiddlewareWithSecrets is like Middleware, but accepts signatures made
+// with any secret supplied by p. This allows rotating the app's client
+// secret without dropping webhooks; the matching secret's ID is reported
+// in Metadata.SecretID.
+func MiddlewareWithSecrets(p SecretProvider, opts ...MiddlewareOption) func(http.Handler) http.Handler {
 	cfg := &middlewareConfig{
-		onVerifyError: func(w http.ResponseWriter, _ *http.Request, _ error) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		},
+		onVerifyError: defaultVerifyError,
 		onParseError: func(w http.ResponseWriter, _ *http.Request, _ error) {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 		},
+		onReplayError: func(w http.ResponseWriter, _ *http.Request, _ error) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		},
 	}
 	for _, opt := range opts {
 		opt(cfg)
@@ -44,7 +57,7 @@ func Middleware(secret string, opts ...MiddlewareOption) func(http.Handler) http
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			body, err := VerifyRequest(secret, r)
+			body, matched, err := VerifyRequestWithSecrets(p, r, cfg.verifyOpts...)
 			if err != nil {
 				cfg.onVerifyError(w, r, err)
 				return
@@ -55,6 +68,12 @@ func Middleware(secret string, opts ...MiddlewareOption) func(http.Handler) http
 				cfg.onParseError(w, r, err)
 				return
 			}
+			meta.SecretID = matched.ID
+
+			if err := cfg.replay.apply(&meta); err != nil {
+				cfg.onReplayError(w, r, err)
+				return
+			}
 
 			event := Event{
 				Metadata: meta,
@@ -76,63 +95,153 @@ func Middleware(secret string, opts ...MiddlewareOption) func(http.Handler) http
 //
 // It responds 200 OK immediately (to satisfy Shopify's 5-second timeout),
 // then dispatches to the router synchronously or asynchronously depending
-// on configuration.
+// on configuration. In async mode, the event is submitted to the
+// AsyncProcessor before responding, and rejected events are answered with
+// 503 so that Shopify redelivers them. In sync mode, context-aware
+// handlers receive the request context.
 func Handler(secret string, router *Router, opts ...HandlerOption) http.Handler {
+	return HandlerWithSecrets(StaticSecret(secret), router, opts...)
+}
+
+// HandlerWithSecrets is like Handler, but accepts signatures made with any
+// secret supplied by p. The matching secret's ID is reported in
+// Metadata.SecretID.
+func HandlerWithSecrets(p SecretProvider, router *Router, opts ...HandlerOption) http.Handler {
+	cfg := newHandlerConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.serve(w, r, p, router)
+	})
+}
+
+func newHandlerConfig(opts []HandlerOption) *handlerConfig {
 	cfg := &handlerConfig{
-		onVerifyError: func(w http.ResponseWriter, _ *http.Request, _ error) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		},
+		onVerifyError: defaultVerifyError,
 		onParseError: func(w http.ResponseWriter, _ *http.Request, _ error) {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 		},
+		onReplayError: func(w http.ResponseWriter, _ *http.Request, _ error) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		},
+		claimLease:     DefaultClaimLease,
+		dedupKey:       EventIDKey,
+		overloadStatus: http.StatusServiceUnavailable,
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return cfg
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, err := VerifyRequest(secret, r)
-		if err != nil {
-			cfg.onVerifyError(w, r, err)
-			return
-		}
+// serve verifies a webhook request against p and dispatches it to router.
+func (cfg *handlerConfig) serve(w http.ResponseWriter, r *http.Request, p SecretProvider, router *Router) {
+	body, matched, err := VerifyRequestWithSecrets(p, r, cfg.verifyOpts...)
+	if err != nil {
+		cfg.onVerifyError(w, r, err)
+		return
+	}
 
-		meta, err := ParseMetadata(r.Header)
-		if err != nil {
-			cfg.onParseError(w, r, err)
+	meta, err := ParseMetadata(r.Header)
+	if err != nil {
+		cfg.onParseError(w, r, err)
+		return
+	}
+	meta.SecretID = matched.ID
+
+	if err := cfg.replay.apply(&meta); err != nil {
+		cfg.onReplayError(w, r, err)
+		return
+	}
+
+	event := Event{
+		Metadata: meta,
+		RawBody:  body,
+	}
+
+	// Dedup: claim the event so a concurrent delivery of the same event
+	// can't be processed at the same time. Events without a key can't be
+	// deduplicated and are always processed.
+	claimed := false
+	var key string
+	if cfg.claims != nil {
+		key = cfg.dedupKey(event)
+	}
+	if key != "" {
+		ctx := context.WithValue(r.Context(), eventContextKey, event)
+		ok, claimErr := cfg.claims.Claim(ctx, key, cfg.claimLease)
+		if errors.Is(claimErr, ErrAlreadyClaimed) {
+			// Another delivery is processing the event. Don't acknowledge
+			// this one: if that delivery fails, Shopify's retry of this
+			// one is what gets the event processed.
+			http.Error(w, "Conflict", http.StatusConflict)
 			return
 		}
-
-		event := Event{
-			Metadata: meta,
-			RawBody:  body,
+		if claimErr == nil && !ok {
+			w.WriteHeader(http.StatusOK)
+			return
 		}
+		// On dedup store errors, process anyway — better to duplicate
+		// than to drop a webhook.
+		claimed = claimErr == nil
+	}
 
-		// Dedup check.
-		if cfg.dedup != nil {
-			processed, checkErr := cfg.dedup.Exists(r.Context(), event.Metadata.EventID)
-			if checkErr == nil && processed {
-				w.WriteHeader(http.StatusOK)
-				return
+	if cfg.async != nil {
+		// Hand the event off before responding, so a durable processor
+		// has persisted it by the time Shopify sees the 200, and an
+		// overloaded one can turn it away.
+		var submitErr error
+		tracker, tracked := cfg.async.(TrackingProcessor)
+		switch {
+		case claimed && tracked:
+			submitErr = tracker.SubmitTracked(event, router, func(event Event, err error) {
+				cfg.settleClaim(event, key, err)
+			})
+			if submitErr != nil {
+				cfg.settleClaim(event, key, submitErr)
 			}
-			// On dedup store errors, process anyway — better to duplicate
-			// than to drop a webhook.
+		case claimed:
+			// The processor can't report the outcome, so mark the event as
+			// processed once it is handed off.
+			submitErr = cfg.async.Submit(event, router)
+			cfg.settleClaim(event, key, submitErr)
+		default:
+			submitErr = cfg.async.Submit(event, router)
+		}
+		if submitErr != nil {
+			cfg.overloaded(w)
+			return
 		}
-
-		// Respond 200 immediately to satisfy Shopify's timeout.
 		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-		if cfg.async != nil {
-			cfg.async.Submit(event, router)
-		} else {
-			_ = router.Dispatch(event)
-		}
+	// Respond 200 immediately to satisfy Shopify's timeout.
+	w.WriteHeader(http.StatusOK)
 
-		// Mark as processed after dispatch is submitted.
-		if cfg.dedup != nil {
-			_ = cfg.dedup.Store(context.Background(), event.Metadata.EventID)
-		}
-	})
+	err = router.DispatchContext(r.Context(), event)
+	if claimed {
+		cfg.settleClaim(event, key, err)
+	}
+}
+
+// overloaded responds to an event the AsyncProcessor rejected, so that
+// Shopify redelivers it later.
+func (cfg *handlerConfig) overloaded(w http.ResponseWriter) {
+	if cfg.retryAfter > 0 {
+		secs := int64((cfg.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	}
+	http.Error(w, http.StatusText(cfg.overloadStatus), cfg.overloadStatus)
+}
+
+// settleClaim completes the claim on key if the event was processed
+// successfully, or releases it so a redelivery can be processed again.
+func (cfg *handlerConfig) settleClaim(event Event, key string, err error) {
+	ctx := context.WithValue(context.Background(), eventContextKey, event)
+	if err != nil {
+		_ = cfg.claims.Release(ctx, key)
+		return
+	}
+	_ = cfg.claims.Complete(ctx, key)
 }
 
 // MiddlewareOption configures the verification Middleware.
@@ -141,6 +250,30 @@ type MiddlewareOption func(*middlewareConfig)
 type middlewareConfig struct {
 	onVerifyError func(http.ResponseWriter, *http.Request, error)
 	onParseError  func(http.ResponseWriter, *http.Request, error)
+	onReplayError func(http.ResponseWriter, *http.Request, error)
+	verifyOpts    []VerifyOption
+	replay        *ReplayWindow
+}
+
+// defaultVerifyError responds 413 for oversized bodies, 400 for requests
+// rejected by WithEarlyHeaderCheck, and 401 for everything else.
+func defaultVerifyError(w http.ResponseWriter, _ *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrMissingTopic):
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+	default:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// WithVerifyOptions configures how the Middleware reads and verifies the
+// request, e.g. WithMaxBodySize or WithEarlyHeaderCheck.
+func WithVerifyOptions(opts ...VerifyOption) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.verifyOpts = append(c.verifyOpts, opts...)
+	}
 }
 
 // WithVerifyErrorHandler customizes the response when HMAC verification fails.
@@ -161,26 +294,87 @@ func WithParseErrorHandler(fn func(http.ResponseWriter, *http.Request, error)) M
 type HandlerOption func(*handlerConfig)
 
 type handlerConfig struct {
-	async         AsyncProcessor
-	dedup         IdempotencyStore
-	onVerifyError func(http.ResponseWriter, *http.Request, error)
-	onParseError  func(http.ResponseWriter, *http.Request, error)
+	async          AsyncProcessor
+	overloadStatus int
+	retryAfter     time.Duration
+	claims         ClaimStore
+	claimLease     time.Duration
+	dedupKey       DedupKeyFunc
+	onVerifyError  func(http.ResponseWriter, *http.Request, error)
+	onParseError   func(http.ResponseWriter, *http.Request, error)
+	onReplayError  func(http.ResponseWriter, *http.Request, error)
+	verifyOpts     []VerifyOption
+	replay         *ReplayWindow
+}
+
+// WithHandlerVerifyOptions configures how the Handler reads and verifies
+// the request, e.g. WithMaxBodySize or WithEarlyHeaderCheck.
+func WithHandlerVerifyOptions(opts ...VerifyOption) HandlerOption {
+	return func(c *handlerConfig) {
+		c.verifyOpts = append(c.verifyOpts, opts...)
+	}
 }
 
 // WithAsyncProcessor configures background event processing.
-// When set, the Handler responds 200 immediately and dispatches
-// the event to the processor in the background.
+// When set, the Handler submits the event to the processor and responds
+// 200 as soon as it is accepted, or 503 Service Unavailable if the
+// processor rejects it (see WithOverloadResponse).
 func WithAsyncProcessor(p AsyncProcessor) HandlerOption {
 	return func(c *handlerConfig) {
 		c.async = p
 	}
 }
 
-// WithIdempotencyStore configures deduplication of webhook events
-// using the X-Shopify-Event-Id header.
+// WithOverloadResponse sets the status the Handler responds with when the
+// AsyncProcessor rejects an event, e.g. with ErrQueueFull. Default: 503
+// Service Unavailable. If retryAfter is positive, it is sent in a
+// Retry-After header, rounded up to whole seconds:
+//
+//	shopifywebhook.WithOverloadResponse(http.StatusTooManyRequests, 30*time.Second)
+//
+// Shopify treats any non-2xx response as a failed delivery and retries it,
+// so its retry schedule becomes the buffer while the processor catches up.
+func WithOverloadResponse(status int, retryAfter time.Duration) HandlerOption {
+	return func(c *handlerConfig) {
+		c.overloadStatus = status
+		c.retryAfter = retryAfter
+	}
+}
+
+// WithIdempotencyStore configures deduplication of webhook events, by
+// default on the X-Shopify-Event-Id header (see WithDedupKey). Stores that
+// also implement ClaimStore (such as MemoryStore) are used through their
+// atomic claim lifecycle; others are wrapped with AdaptIdempotencyStore.
 func WithIdempotencyStore(s IdempotencyStore) HandlerOption {
 	return func(c *handlerConfig) {
-		c.dedup = s
+		c.claims = AdaptIdempotencyStore(s)
+	}
+}
+
+// WithClaimStore configures deduplication of webhook events using a
+// ClaimStore: each event is claimed before dispatch, completed on success
+// and released on failure.
+func WithClaimStore(s ClaimStore) HandlerOption {
+	return func(c *handlerConfig) {
+		c.claims = s
+	}
+}
+
+// WithClaimLease sets how long a claim is held before another delivery of
+// the same event may claim it, in case the process dies mid-dispatch.
+// Default: DefaultClaimLease.
+func WithClaimLease(d time.Duration) HandlerOption {
+	return func(c *handlerConfig) {
+		c.claimLease = d
+	}
+}
+
+// WithDedupKey sets how the key an event is deduplicated on is derived.
+// Default: EventIDKey. Events for which fn returns an empty key are
+// processed without deduplication.
+func WithDedupKey(fn DedupKeyFunc) HandlerOption {
+	return func(c *handlerConfig) {
+		c.dedupKey = fn
 	}
 }
 
@@ -199,3 +393,36 @@ func WithHandlerParseErrorHandler(fn func(http.ResponseWriter, *http.Request, er
 		c.onParseError = fn
 	}
 }
+
+// WithReplayWindow rejects events outside the given freshness window (see
+// ReplayWindow) with 401 Unauthorized, or flags them if rw.FlagOnly is set.
+func WithReplayWindow(rw ReplayWindow) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.replay = &rw
+	}
+}
+
+// WithReplayErrorHandler customizes the response when an event falls
+// outside the replay window.
+func WithReplayErrorHandler(fn func(http.ResponseWriter, *http.Request, error)) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.onReplayError = fn
+	}
+}
+
+// WithHandlerReplayWindow rejects events outside the given freshness window
+// (see ReplayWindow) with 401 Unauthorized, or flags them if rw.FlagOnly
+// is set.
+func WithHandlerReplayWindow(rw ReplayWindow) HandlerOption {
+	return func(c *handlerConfig) {
+		c.replay = &rw
+	}
+}
+
+// WithHandlerReplayErrorHandler customizes the response when an event falls
+// outside the replay window in the Handler.
+func WithHandlerReplayErrorHandler(fn func(http.ResponseWriter, *http.Request, error)) HandlerOption {
+	return func(c *handlerConfig) {
+		c.onReplayError = fn
+	}
+}
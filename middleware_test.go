@@ -2,9 +2,12 @@ package shopifywebhook
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -198,3 +201,231 @@ func TestEventFromContext_Missing(t *testing.T) {
 		t.Fatal("expected ok=false for empty context")
 	}
 }
+
+func TestHandler_SyncPanicRecovered(t *testing.T) {
+	secret := "test-secret"
+
+	var captured error
+	router := NewRouter(WithErrorHandler(func(event Event, err error) {
+		captured = err
+	}))
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		panic("boom")
+	})
+
+	handler := Handler(secret, router)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest(secret, `{}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if _, ok := captured.(*PanicError); !ok {
+		t.Fatalf("expected *PanicError, got %v", captured)
+	}
+}
+
+func TestHandler_BodyTooLarge(t *testing.T) {
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		t.Fatal("handler should not be called")
+		return nil
+	})
+
+	handler := Handler("secret", router, WithHandlerVerifyOptions(WithMaxBodySize(8)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("secret", `{"id":123456789}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+}
+
+func TestMiddleware_EarlyHeaderCheck(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("inner handler should not be called")
+	})
+
+	handler := Middleware("secret", WithVerifyOptions(WithEarlyHeaderCheck()))(inner)
+	req := signedRequest("secret", `{}`, TopicOrdersCreate)
+	req.Header.Del("X-Shopify-Topic")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestHandler_WithDedup_ConcurrentDeliveries(t *testing.T) {
+	secret := "test-secret"
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls.Add(1)
+		<-release
+		return nil
+	})
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	handler := Handler(secret, router, WithIdempotencyStore(store))
+
+	recs := make([]*httptest.ResponseRecorder, 5)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(recs[i], signedRequest(secret, `{"id":1}`, TopicOrdersCreate))
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 dispatch for concurrent duplicates, got %d", got)
+	}
+	// Duplicates arriving while the event is in flight must not be
+	// acknowledged, or nothing redelivers it if that attempt fails.
+	codes := map[int]int{}
+	for _, rec := range recs {
+		codes[rec.Code]++
+	}
+	if codes[http.StatusOK] != 1 || codes[http.StatusConflict] != 4 {
+		t.Fatalf("expected one 200 and four 409s, got %v", codes)
+	}
+}
+
+func TestHandler_WithDedup_ReleasesOnFailure(t *testing.T) {
+	secret := "test-secret"
+
+	var calls int
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls++
+		if calls == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	handler := Handler(secret, router, WithIdempotencyStore(store))
+
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), signedRequest(secret, `{"id":1}`, TopicOrdersCreate))
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected failed event to be re-processed once, got %d calls", calls)
+	}
+}
+
+func TestHandler_WithDedup_AsyncReleasesOnFailure(t *testing.T) {
+	secret := "test-secret"
+
+	var calls atomic.Int32
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		if calls.Add(1) == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	pool := NewWorkerPool(1, 10)
+	handler := Handler(secret, router, WithIdempotencyStore(store), WithAsyncProcessor(pool))
+
+	waitSettled := func() {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			// Settled once the claim is either released or completed.
+			done, _ := store.Exists(context.Background(), "event-123")
+			if done || store.Stats().Size == 0 {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for async dispatch")
+	}
+
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), signedRequest(secret, `{"id":1}`, TopicOrdersCreate))
+		waitSettled()
+	}
+	_ = pool.Shutdown(context.Background())
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected failed async event to be re-processed once, got %d calls", got)
+	}
+	if ok, _ := store.Exists(context.Background(), "event-123"); !ok {
+		t.Fatal("expected event to be marked processed after success")
+	}
+}
+
+func TestHandler_AsyncRejectedEventResponds503(t *testing.T) {
+	secret := "test-secret"
+
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	pool := NewWorkerPool(1, 10)
+	_ = pool.Shutdown(context.Background())
+	handler := Handler(secret, NewRouter(), WithIdempotencyStore(store), WithAsyncProcessor(pool))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest(secret, `{"id":1}`, TopicOrdersCreate))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatal("expected no Retry-After header by default")
+	}
+	// The claim must be released so Shopify's redelivery is processed.
+	if ok, _ := store.Claim(context.Background(), "event-123", time.Minute); !ok {
+		t.Fatal("expected the rejected event's claim to be released")
+	}
+}
+
+func TestHandler_WithOverloadResponse(t *testing.T) {
+	secret := "test-secret"
+
+	block := make(chan struct{})
+	defer close(block)
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		<-block
+		return nil
+	})
+	pool := NewWorkerPool(1, 1)
+	handler := Handler(secret, router,
+		WithAsyncProcessor(pool),
+		WithOverloadResponse(http.StatusTooManyRequests, 1500*time.Millisecond),
+	)
+
+	// The first event occupies the worker and the second fills the queue.
+	var codes []int
+	for range 4 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, signedRequest(secret, `{"id":1}`, TopicOrdersCreate))
+		codes = append(codes, rec.Code)
+		if rec.Code == http.StatusTooManyRequests {
+			if got := rec.Header().Get("Retry-After"); got != "2" {
+				t.Fatalf("expected Retry-After rounded up to 2, got %q", got)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if codes[0] != http.StatusOK || codes[3] != http.StatusTooManyRequests {
+		t.Fatalf("expected 200 then 429 once the queue is full, got %v", codes)
+	}
+}
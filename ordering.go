@@ -0,0 +1,218 @@
+package shopifywebhook
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VersionStore remembers the latest updated_at processed for each resource, so
+// OrderingGuard can tell stale events from current ones.
+//
+// Implement this interface for your storage backend:
+//   - Redis: a Lua script comparing and setting the stored timestamp
+//   - PostgreSQL: INSERT ... ON CONFLICT DO UPDATE ... WHERE stored < new
+type VersionStore interface {
+	// IsStale reports whether a version newer than version is stored for
+	// key, meaning the event carrying version is stale. An equal version
+	// is not stale: Shopify sends several topics, and retries, for one
+	// change.
+	IsStale(ctx context.Context, key string, version time.Time) (bool, error)
+
+	// Advance records version for key unless a newer one is already
+	// stored, atomically. It returns false if the stored version is newer.
+	Advance(ctx context.Context, key string, version time.Time) (bool, error)
+}
+
+// ResourceVersion identifies the resource an event is about and the
+// version of it the event carries.
+type ResourceVersion struct {
+	// Key identifies the resource across topics, as
+	// "<kind>:<shop domain>:<id>", e.g. "product:example.myshopify.com:42".
+	Key string
+
+	// UpdatedAt is the payload's updated_at.
+	UpdatedAt time.Time
+}
+
+// versionedKind names the resource for topics whose built-in payload type
+// is versioned by updated_at, for use in ResourceVersion keys.
+func versionedKind(topic Topic) string {
+	payload, ok := NewPayload(topic)
+	if !ok {
+		return ""
+	}
+	switch payload.(type) {
+	case *Order:
+		return "order"
+	case *Product:
+		return "product"
+	case *Customer:
+		return "customer"
+	case *Collection:
+		return "collection"
+	}
+	return ""
+}
+
+// ExtractVersion returns the resource version carried by an event whose
+// topic's payload is an Order, Product, Customer or Collection. It returns
+// false for other topics, and for payloads without an id or a valid
+// updated_at, such as the bare IDs sent on */delete.
+func ExtractVersion(event Event) (ResourceVersion, bool) {
+	kind := versionedKind(event.Metadata.Topic)
+	if kind == "" {
+		return ResourceVersion{}, false
+	}
+
+	// Only the two fields are needed, so don't decode the whole payload.
+	var fields struct {
+		ID        int64  `json:"id"`
+		UpdatedAt string `json:"updated_at"`
+	}
+	if err := event.Unmarshal(&fields); err != nil || fields.ID == 0 {
+		return ResourceVersion{}, false
+	}
+	updatedAt, err := time.Parse(time.RFC3339, fields.UpdatedAt)
+	if err != nil {
+		return ResourceVersion{}, false
+	}
+	return ResourceVersion{
+		Key:       kind + ":" + event.Metadata.ShopDomain + ":" + strconv.FormatInt(fields.ID, 10),
+		UpdatedAt: updatedAt,
+	}, true
+}
+
+// OrderingGuardOption configures an OrderingGuard.
+type OrderingGuardOption func(*orderingGuardConfig)
+
+type orderingGuardConfig struct {
+	onStale func(event Event, v ResourceVersion)
+}
+
+// WithStaleHandler sets a function called for each event OrderingGuard
+// skips, e.g. to log or count them.
+func WithStaleHandler(fn func(event Event, v ResourceVersion)) OrderingGuardOption {
+	return func(c *orderingGuardConfig) {
+		c.onStale = fn
+	}
+}
+
+// OrderingGuard returns DispatchMiddleware that skips events carrying an
+// older version of their resource than one already seen, so a delayed
+// products/update can't overwrite newer data. Shopify doesn't guarantee
+// delivery order, but every Order, Product, Customer and Collection payload
+// carries updated_at (see ExtractVersion).
+//
+//	versions := shopifywebhook.NewMemoryVersionStore(72 * time.Hour)
+//	router.Use(shopifywebhook.OrderingGuard(versions))
+//
+// A version is only recorded once the handler for its event succeeds, so
+// a failed event doesn't make older ones stale: they never applied a newer
+// version. Skipped events return nil, so they are neither retried nor
+// reported. Events without a version pass through, as do all events if the
+// store fails — better to process a stale event than to drop a current one.
+func OrderingGuard(store VersionStore, opts ...OrderingGuardOption) DispatchMiddleware {
+	cfg := &orderingGuardConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx context.Context, event Event) error {
+			v, ok := ExtractVersion(event)
+			if !ok {
+				return next(ctx, event)
+			}
+			if stale, err := store.IsStale(ctx, v.Key, v.UpdatedAt); err == nil && stale {
+				if cfg.onStale != nil {
+					cfg.onStale(event, v)
+				}
+				return nil
+			}
+			if err := next(ctx, event); err != nil {
+				return err
+			}
+			// A failure to record the version only lets a stale event
+			// through later, so it doesn't fail the event.
+			_, _ = store.Advance(ctx, v.Key, v.UpdatedAt)
+			return nil
+		}
+	}
+}
+
+// MemoryVersionStore is an in-memory VersionStore suitable for
+// single-instance deployments. A resource's version is forgotten once it
+// hasn't been advanced for the configured TTL.
+type MemoryVersionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	versions map[string]versionEntry
+	done     chan struct{}
+}
+
+type versionEntry struct {
+	version time.Time
+	expires time.Time
+}
+
+// NewMemoryVersionStore creates a MemoryVersionStore with the given TTL.
+//
+// The TTL bounds how late a stale event can arrive and still be caught.
+// Shopify retries for up to 48 hours, so use at least that.
+func NewMemoryVersionStore(ttl time.Duration) *MemoryVersionStore {
+	s := &MemoryVersionStore{
+		ttl:      ttl,
+		versions: make(map[string]versionEntry),
+		done:     make(chan struct{}),
+	}
+	go s.cleanup(ttl / 2)
+	return s
+}
+
+// IsStale reports whether a newer version than version is stored for key.
+func (s *MemoryVersionStore) IsStale(_ context.Context, key string, version time.Time) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.versions[key]
+	return ok && now.Before(e.expires) && e.version.After(version), nil
+}
+
+// Advance records version for key unless a newer one is stored.
+func (s *MemoryVersionStore) Advance(_ context.Context, key string, version time.Time) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.versions[key]; ok && now.Before(e.expires) && e.version.After(version) {
+		return false, nil
+	}
+	s.versions[key] = versionEntry{version: version, expires: now.Add(s.ttl)}
+	return true, nil
+}
+
+// Close stops the background cleanup goroutine.
+func (s *MemoryVersionStore) Close() {
+	close(s.done)
+}
+
+func (s *MemoryVersionStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for key, e := range s.versions {
+				if !now.Before(e.expires) {
+					delete(s.versions, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func productUpdate(updatedAt string) Event {
+	return Event{
+		Metadata: Metadata{Topic: TopicProductsUpdate, ShopDomain: "test.myshopify.com"},
+		RawBody:  []byte(`{"id":42,"title":"Shirt","updated_at":"` + updatedAt + `"}`),
+	}
+}
+
+func TestExtractVersion(t *testing.T) {
+	v, ok := ExtractVersion(productUpdate("2025-01-02T10:00:00-05:00"))
+	if !ok {
+		t.Fatal("expected a version for products/update")
+	}
+	if v.Key != "product:test.myshopify.com:42" {
+		t.Fatalf("unexpected key %q", v.Key)
+	}
+	if !v.UpdatedAt.Equal(time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected updated_at %v", v.UpdatedAt)
+	}
+
+	// orders/create and orders/updated version the same resource.
+	create, _ := ExtractVersion(Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate, ShopDomain: "test.myshopify.com"},
+		RawBody:  []byte(`{"id":7,"updated_at":"2025-01-02T10:00:00Z"}`),
+	})
+	update, _ := ExtractVersion(Event{
+		Metadata: Metadata{Topic: TopicOrdersUpdate, ShopDomain: "test.myshopify.com"},
+		RawBody:  []byte(`{"id":7,"updated_at":"2025-01-02T10:00:00Z"}`),
+	})
+	if create.Key != update.Key {
+		t.Fatalf("expected one key across topics, got %q and %q", create.Key, update.Key)
+	}
+
+	for _, event := range []Event{
+		{Metadata: Metadata{Topic: TopicProductsDelete}, RawBody: []byte(`{"id":42}`)},
+		{Metadata: Metadata{Topic: TopicRefundsCreate}, RawBody: []byte(`{"id":1,"created_at":"2025-01-02T10:00:00Z"}`)},
+		{Metadata: Metadata{Topic: TopicProductsUpdate}, RawBody: []byte(`not json`)},
+	} {
+		if _, ok := ExtractVersion(event); ok {
+			t.Fatalf("expected no version for %s %s", event.Metadata.Topic, event.RawBody)
+		}
+	}
+}
+
+func TestOrderingGuard_SkipsStaleEvents(t *testing.T) {
+	versions := NewMemoryVersionStore(time.Hour)
+	defer versions.Close()
+
+	var handled []string
+	var stale []string
+	router := NewRouter()
+	router.Use(OrderingGuard(versions, WithStaleHandler(func(event Event, v ResourceVersion) {
+		stale = append(stale, v.UpdatedAt.Format(time.RFC3339))
+	})))
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		v, _ := ExtractVersion(event)
+		handled = append(handled, v.UpdatedAt.Format(time.RFC3339))
+		return nil
+	})
+
+	for _, ts := range []string{
+		"2025-01-02T10:00:00Z",
+		"2025-01-02T12:00:00Z",
+		"2025-01-02T11:00:00Z", // delivered late
+		"2025-01-02T12:00:00Z", // same version, e.g. a retry
+	} {
+		if err := router.Dispatch(productUpdate(ts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(handled) != 3 || handled[2] != "2025-01-02T12:00:00Z" {
+		t.Fatalf("unexpected handled events %v", handled)
+	}
+	if len(stale) != 1 || stale[0] != "2025-01-02T11:00:00Z" {
+		t.Fatalf("unexpected stale events %v", stale)
+	}
+}
+
+func TestOrderingGuard_StoreErrorPassesThrough(t *testing.T) {
+	store := failingVersionStore{errors.New("unavailable")}
+
+	called := false
+	router := NewRouter()
+	router.Use(OrderingGuard(store))
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		called = true
+		return nil
+	})
+
+	_ = router.Dispatch(productUpdate("2025-01-02T10:00:00Z"))
+	if !called {
+		t.Fatal("expected the event to be processed when the store fails")
+	}
+}
+
+func TestOrderingGuard_FailedEventDoesNotAdvance(t *testing.T) {
+	versions := NewMemoryVersionStore(time.Hour)
+	defer versions.Close()
+
+	var handled []string
+	router := NewRouter()
+	router.Use(OrderingGuard(versions))
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		v, _ := ExtractVersion(event)
+		if v.UpdatedAt.Hour() == 12 {
+			return Permanent(errors.New("rejected"))
+		}
+		handled = append(handled, v.UpdatedAt.Format(time.RFC3339))
+		return nil
+	})
+
+	if err := router.Dispatch(productUpdate("2025-01-02T12:00:00Z")); err == nil {
+		t.Fatal("expected the handler error to be returned")
+	}
+	if err := router.Dispatch(productUpdate("2025-01-02T11:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 || handled[0] != "2025-01-02T11:00:00Z" {
+		t.Fatalf("expected the older event to be processed after the newer one failed, got %v", handled)
+	}
+}
+
+type failingVersionStore struct{ err error }
+
+func (s failingVersionStore) IsStale(context.Context, string, time.Time) (bool, error) {
+	return false, s.err
+}
+
+func (s failingVersionStore) Advance(context.Context, string, time.Time) (bool, error) {
+	return false, s.err
+}
@@ -0,0 +1,120 @@
+package shopifywebhook
+
+import "sync"
+
+// PartitionKeyFunc derives the key a WorkerPool partitions an event on (see
+// WithPartitionKey). Events with the same key are processed one at a time,
+// in the order they were submitted.
+//
+// An empty key means the event has no ordering constraints: it is processed
+// on its own, rather than serialized with every other unkeyed event.
+type PartitionKeyFunc func(event Event) string
+
+// ShopPartitionKey partitions events by the X-Shopify-Shop-Domain header,
+// so each shop's events are processed in order and no shop can monopolize
+// the workers.
+func ShopPartitionKey(event Event) string {
+	return event.Metadata.ShopDomain
+}
+
+// keyedQueue is the WorkerPool's queue when events are partitioned. Each
+// partition runs one event at a time, from its submission until its final
+// outcome, including any retries. Partitions with an event waiting take
+// turns in a FIFO ring, so a partition with a large backlog gets one event
+// processed per turn like every other.
+type keyedQueue struct {
+	keyFn    PartitionKeyFunc
+	capacity int
+
+	mu      sync.Mutex
+	queued  int                   // events waiting in partitions
+	parts   map[string]*partition // keyed partitions with an event waiting or running
+	ready   []*partition          // partitions with an event waiting and none running
+	changed chan struct{}         // closed and replaced whenever ready grows
+}
+
+type partition struct {
+	key     string
+	pending []work
+}
+
+func newKeyedQueue(keyFn PartitionKeyFunc, capacity int) *keyedQueue {
+	return &keyedQueue{
+		keyFn:    keyFn,
+		capacity: capacity,
+		parts:    make(map[string]*partition),
+		changed:  make(chan struct{}),
+	}
+}
+
+// push queues w in its partition. It returns false if the queue is full.
+func (q *keyedQueue) push(w work) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued >= q.capacity {
+		return false
+	}
+	q.queued++
+
+	key := q.keyFn(w.event)
+	if key == "" {
+		q.markReady(&partition{pending: []work{w}})
+		return true
+	}
+	part, ok := q.parts[key]
+	if !ok {
+		part = &partition{key: key}
+		q.parts[key] = part
+		q.markReady(part)
+	}
+	part.pending = append(part.pending, w)
+	return true
+}
+
+// pop takes the next event from the partition whose turn it is, marking
+// that partition as running. If no partition is ready, it returns false and
+// a channel that is closed once one may be.
+func (q *keyedQueue) pop() (work, bool, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ready) == 0 {
+		return work{}, false, q.changed
+	}
+	part := q.ready[0]
+	q.ready[0] = nil
+	q.ready = q.ready[1:]
+
+	w := part.pending[0]
+	part.pending[0] = work{}
+	part.pending = part.pending[1:]
+	q.queued--
+	w.part = part
+	return w, true, nil
+}
+
+// release marks part's running event as finished, giving the partition
+// another turn if it has events waiting.
+func (q *keyedQueue) release(part *partition) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(part.pending) > 0 {
+		q.markReady(part)
+	} else if part.key != "" {
+		delete(q.parts, part.key)
+	}
+}
+
+// len returns the number of events waiting in partitions.
+func (q *keyedQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// markReady puts part at the back of the ring and wakes waiting workers.
+// Callers must hold q.mu.
+func (q *keyedQueue) markReady(part *partition) {
+	q.ready = append(q.ready, part)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
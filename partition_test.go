@@ -0,0 +1,169 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func shopEvent(shop, id string) Event {
+	return Event{
+		Metadata: Metadata{Topic: TopicOrdersUpdate, ShopDomain: shop, EventID: id},
+		RawBody:  []byte(`{}`),
+	}
+}
+
+func TestWorkerPool_PartitionProcessesKeyInOrder(t *testing.T) {
+	var running atomic.Int32
+	var mu sync.Mutex
+	var order []string
+
+	router := NewRouter()
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		if running.Add(1) > 1 {
+			t.Error("events for one shop ran concurrently")
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		order = append(order, event.Metadata.EventID)
+		mu.Unlock()
+		running.Add(-1)
+		return nil
+	})
+
+	pool := NewWorkerPool(4, 100, WithPartitionKey(ShopPartitionKey))
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		if err := pool.Submit(shopEvent("a.myshopify.com", id), router); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range order {
+		if want := string(rune('1' + i)); id != want {
+			t.Fatalf("expected events in submission order, got %v", order)
+		}
+	}
+}
+
+func TestWorkerPool_PartitionRoundRobin(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+
+	router := NewRouter()
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		if event.Metadata.EventID == "a1" {
+			<-release
+		}
+		mu.Lock()
+		order = append(order, event.Metadata.EventID)
+		mu.Unlock()
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 100, WithPartitionKey(ShopPartitionKey))
+	for _, id := range []string{"a1", "a2", "a3", "a4"} {
+		_ = pool.Submit(shopEvent("a.myshopify.com", id), router)
+	}
+	_ = pool.Submit(shopEvent("b.myshopify.com", "b1"), router)
+	_ = pool.Submit(shopEvent("c.myshopify.com", "c1"), router)
+	_ = pool.Submit(shopEvent("b.myshopify.com", "b2"), router)
+	close(release)
+	_ = pool.Shutdown(context.Background())
+
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3", "a4"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected shops to take turns %v, got %v", want, order)
+		}
+	}
+}
+
+func TestWorkerPool_PartitionRetryHoldsBackKey(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	var failed atomic.Bool
+
+	router := NewRouter()
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		if event.Metadata.EventID == "a1" && !failed.Swap(true) {
+			return errors.New("transient")
+		}
+		mu.Lock()
+		order = append(order, event.Metadata.EventID)
+		mu.Unlock()
+		return nil
+	})
+
+	pool := NewWorkerPool(2, 100,
+		WithPartitionKey(ShopPartitionKey),
+		WithMaxRetries(1),
+		WithRetryBaseDelay(30*time.Millisecond),
+	)
+	_ = pool.Submit(shopEvent("a.myshopify.com", "a1"), router)
+	_ = pool.Submit(shopEvent("a.myshopify.com", "a2"), router)
+	_ = pool.Submit(shopEvent("b.myshopify.com", "b1"), router)
+	_ = pool.Shutdown(context.Background())
+
+	// b1 goes ahead while a1 backs off, but a2 waits for a1.
+	want := []string{"b1", "a1", "a2"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, order)
+		}
+	}
+}
+
+func TestWorkerPool_PartitionEmptyKeyRunsConcurrently(t *testing.T) {
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+
+	router := NewRouter()
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		arrived.Done()
+		arrived.Wait() // both must run at once to get past here
+		return nil
+	})
+
+	pool := NewWorkerPool(2, 10, WithPartitionKey(ShopPartitionKey))
+	_ = pool.Submit(shopEvent("", "1"), router)
+	_ = pool.Submit(shopEvent("", "2"), router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Fatalf("expected unkeyed events to run concurrently: %v", err)
+	}
+}
+
+func TestWorkerPool_PartitionQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	router := NewRouter()
+	router.Handle(TopicOrdersUpdate, func(event Event) error {
+		<-block
+		return nil
+	})
+
+	pool := NewWorkerPool(1, 2, WithPartitionKey(ShopPartitionKey))
+	var rejected int
+	for i := range 5 {
+		shop := string(rune('a'+i)) + ".myshopify.com"
+		if err := pool.Submit(shopEvent(shop, "1"), router); errors.Is(err, ErrQueueFull) {
+			rejected++
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(block)
+	_ = pool.Shutdown(context.Background())
+
+	// One running and two waiting.
+	if rejected != 2 {
+		t.Fatalf("expected 2 rejected events, got %d", rejected)
+	}
+}
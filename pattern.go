@@ -0,0 +1,56 @@
+package shopifywebhook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsPattern reports whether the topic contains a "*" wildcard segment,
+// such as "orders/*" or "*/delete".
+func (t Topic) IsPattern() bool {
+	return strings.Contains(string(t), "*")
+}
+
+// Match reports whether topic matches t. A "*" segment in t matches exactly
+// one segment of topic; all other segments must be equal. A topic without
+// wildcards only matches itself.
+func (t Topic) Match(topic Topic) bool {
+	pattern := strings.Split(string(t), "/")
+	segments := strings.Split(string(topic), "/")
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validatePattern panics if pattern uses "*" other than as a whole segment.
+func validatePattern(pattern Topic) {
+	for _, seg := range strings.Split(string(pattern), "/") {
+		if seg != "*" && strings.Contains(seg, "*") {
+			panic(fmt.Sprintf("shopifywebhook: invalid topic pattern %q: \"*\" must be a whole segment", pattern))
+		}
+	}
+}
+
+// morePrecise reports whether pattern a takes precedence over b.
+//
+// Segments are compared left to right: at the first position where one
+// pattern has a literal and the other a wildcard, the literal wins. So
+// "orders/*" beats "*/delete", which beats "*/*". Ties are broken by
+// string order to keep precedence deterministic.
+func morePrecise(a, b Topic) bool {
+	as := strings.Split(string(a), "/")
+	bs := strings.Split(string(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		aWild, bWild := as[i] == "*", bs[i] == "*"
+		if aWild != bWild {
+			return bWild
+		}
+	}
+	return a < b
+}
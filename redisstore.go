@@ -0,0 +1,201 @@
+package shopifywebhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultRedisPrefix is the prefix RedisStore puts in front of every key
+// unless configured otherwise with WithRedisPrefix.
+const DefaultRedisPrefix = "shopifywebhook:"
+
+// redisDone is the value stored under a completed event's key. A claimed
+// event's key holds the claim's token instead.
+const redisDone = "done"
+
+// releaseScript deletes a key only while it still holds the caller's
+// claim, so a Release can never drop a completed event or another
+// instance's claim.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// completeScript marks a key done only while it still holds the caller's
+// claim, so a Complete after the lease expired can't overwrite another
+// instance's claim.
+const completeScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3]) end return 0`
+
+// RedisStore is an IdempotencyStore and ClaimStore backed by Redis, for
+// deployments with several instances behind one endpoint. Claims use
+// SET NX PX, so they are atomic across processes, and Redis expires keys
+// on its own, so there is no cleanup to run. Each claim stores a random
+// token, so an instance whose lease expired can't release or complete the
+// claim another instance has since made.
+//
+// It talks to Redis through the minimal RedisClient interface: use
+// NewRESPClient, or adapt the client library you already have.
+type RedisStore struct {
+	client   RedisClient
+	ttl      time.Duration
+	prefix   string
+	shopKeys bool
+
+	mu     sync.Mutex
+	tokens map[string]string // key -> token of this store's claim on it
+}
+
+// RedisStoreOption configures a RedisStore.
+type RedisStoreOption func(*RedisStore)
+
+// WithRedisPrefix sets the prefix of every key. Default: DefaultRedisPrefix.
+func WithRedisPrefix(prefix string) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.prefix = prefix
+	}
+}
+
+// WithRedisShopKeys scopes keys by shop as "<prefix><shop domain>:<event
+// ID>", taking the shop from the event Handler passes in the context (see
+// EventFromContext). This keeps each shop's keys together, e.g. to delete
+// them on shop/redact. Calls without an event in the context use unscoped
+// keys, so don't mix them with Handler's calls on the same events.
+func WithRedisShopKeys() RedisStoreOption {
+	return func(s *RedisStore) {
+		s.shopKeys = true
+	}
+}
+
+// NewRedisStore creates a RedisStore that remembers completed events for
+// ttl. Typical TTL: 24 hours, as for NewMemoryStore.
+//
+//	client := shopifywebhook.NewRESPClient("localhost:6379")
+//	store := shopifywebhook.NewRedisStore(client, 24*time.Hour)
+func NewRedisStore(client RedisClient, ttl time.Duration, opts ...RedisStoreOption) *RedisStore {
+	s := &RedisStore{
+		client: client,
+		ttl:    ttl,
+		prefix: DefaultRedisPrefix,
+		tokens: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *RedisStore) key(ctx context.Context, eventID string) string {
+	if s.shopKeys {
+		if event, ok := EventFromContext(ctx); ok && event.Metadata.ShopDomain != "" {
+			return s.prefix + event.Metadata.ShopDomain + ":" + eventID
+		}
+	}
+	return s.prefix + eventID
+}
+
+// Exists checks if the event ID has been completed within the TTL window.
+// Events that are only claimed are not reported as existing.
+func (s *RedisStore) Exists(ctx context.Context, eventID string) (bool, error) {
+	v, err := s.client.Do(ctx, "GET", s.key(ctx, eventID))
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: redis store exists: %w", err)
+	}
+	return v == redisDone, nil
+}
+
+// Store records an event ID as completed for the store's TTL.
+func (s *RedisStore) Store(ctx context.Context, eventID string) error {
+	_, err := s.client.Do(ctx, "SET", s.key(ctx, eventID), redisDone, "PX", milliseconds(s.ttl))
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: redis store complete: %w", err)
+	}
+	return nil
+}
+
+// Claim atomically reserves the event ID for lease. It fails if the event
+// was completed within the TTL, or with ErrAlreadyClaimed if it is claimed
+// under an unexpired lease.
+func (s *RedisStore) Claim(ctx context.Context, eventID string, lease time.Duration) (bool, error) {
+	key := s.key(ctx, eventID)
+	token, err := newClaimToken()
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: redis store claim: %w", err)
+	}
+	v, err := s.client.Do(ctx, "SET", key, token, "NX", "PX", milliseconds(lease))
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: redis store claim: %w", err)
+	}
+	if v != nil {
+		s.mu.Lock()
+		s.tokens[key] = token
+		s.mu.Unlock()
+		return true, nil
+	}
+
+	// Tell a completed event from a claimed one. A key that expired in the
+	// meantime is reported as claimed, so the delivery is retried rather
+	// than acknowledged.
+	v, err = s.client.Do(ctx, "GET", key)
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: redis store claim: %w", err)
+	}
+	if v == redisDone {
+		return false, nil
+	}
+	return false, ErrAlreadyClaimed
+}
+
+// Complete marks the event ID as processed for the store's TTL. If this
+// store's claim on it has been taken over by another instance after its
+// lease expired, the key is left to that instance.
+func (s *RedisStore) Complete(ctx context.Context, eventID string) error {
+	key := s.key(ctx, eventID)
+	token, ok := s.takeToken(key)
+	if !ok {
+		return s.Store(ctx, eventID)
+	}
+	_, err := s.client.Do(ctx, "EVAL", completeScript, 1, key, token, redisDone, milliseconds(s.ttl))
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: redis store complete: %w", err)
+	}
+	return nil
+}
+
+// Release drops this store's uncompleted claim on the event ID, unless
+// another instance has claimed it since.
+func (s *RedisStore) Release(ctx context.Context, eventID string) error {
+	key := s.key(ctx, eventID)
+	token, ok := s.takeToken(key)
+	if !ok {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "EVAL", releaseScript, 1, key, token)
+	if err != nil {
+		return fmt.Errorf("shopifywebhook: redis store release: %w", err)
+	}
+	return nil
+}
+
+// takeToken returns and forgets the token of this store's claim on key.
+func (s *RedisStore) takeToken(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[key]
+	delete(s.tokens, key)
+	return token, ok
+}
+
+// newClaimToken returns a random value identifying one claim.
+func newClaimToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// milliseconds returns d in whole milliseconds, at least 1 as PX requires.
+func milliseconds(d time.Duration) int64 {
+	return max(d.Milliseconds(), 1)
+}
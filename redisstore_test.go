@@ -0,0 +1,352 @@
+package shopifywebhook
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process RESP server implementing the handful of
+// commands RedisStore and RESPClient use.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu   sync.Mutex
+	data map[string]fakeRedisEntry
+}
+
+type fakeRedisEntry struct {
+	value   string
+	expires time.Time
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: make(map[string]fakeRedisEntry)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if cmd == "AUTH" {
+			if args[1] != f.password {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+			continue
+		}
+		if !authed {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		io.WriteString(conn, f.exec(cmd, args[1:]))
+	}
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	get := func(key string) (string, bool) {
+		e, ok := f.data[key]
+		if !ok || (!e.expires.IsZero() && time.Now().After(e.expires)) {
+			delete(f.data, key)
+			return "", false
+		}
+		return e.value, true
+	}
+
+	switch cmd {
+	case "PING", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := get(args[0])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		key, value := args[0], args[1]
+		var nx bool
+		var expires time.Time
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			}
+		}
+		if _, exists := get(key); nx && exists {
+			return "$-1\r\n"
+		}
+		f.data[key] = fakeRedisEntry{value: value, expires: expires}
+		return "+OK\r\n"
+	case "EVAL":
+		// Only RedisStore's scripts, which act on KEYS[1] if it holds ARGV[1].
+		key, want := args[2], args[3]
+		v, ok := get(key)
+		if !ok || v != want {
+			return ":0\r\n"
+		}
+		switch args[0] {
+		case releaseScript:
+			delete(f.data, key)
+			return ":1\r\n"
+		case completeScript:
+			ms, _ := strconv.Atoi(args[5])
+			f.data[key] = fakeRedisEntry{value: args[4], expires: time.Now().Add(time.Duration(ms) * time.Millisecond)}
+			return "+OK\r\n"
+		}
+		return "-ERR unknown script\r\n"
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd)
+	}
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readRESPReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) == 0 {
+		return nil, errors.New("expected command array")
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+func TestRedisStore_ClaimLifecycle(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	client := NewRESPClient(srv.addr())
+	defer client.Close()
+	store := NewRedisStore(client, time.Hour)
+	ctx := context.Background()
+
+	ok, err := store.Claim(ctx, "evt", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected first claim to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := store.Claim(ctx, "evt", time.Minute); ok || !errors.Is(err, ErrAlreadyClaimed) {
+		t.Fatalf("expected concurrent claim to fail with ErrAlreadyClaimed, got %v, %v", ok, err)
+	}
+	if exists, _ := store.Exists(ctx, "evt"); exists {
+		t.Fatal("expected a claimed event not to exist yet")
+	}
+
+	_ = store.Release(ctx, "evt")
+	if ok, _ := store.Claim(ctx, "evt", time.Minute); !ok {
+		t.Fatal("expected claim to succeed after release")
+	}
+
+	_ = store.Complete(ctx, "evt")
+	if exists, _ := store.Exists(ctx, "evt"); !exists {
+		t.Fatal("expected completed event to exist")
+	}
+	if ok, err := store.Claim(ctx, "evt", time.Minute); ok || err != nil {
+		t.Fatalf("expected claim on a completed event to fail without error, got %v, %v", ok, err)
+	}
+	_ = store.Release(ctx, "evt")
+	if exists, _ := store.Exists(ctx, "evt"); !exists {
+		t.Fatal("expected release not to drop a completed event")
+	}
+}
+
+func TestRedisStore_ExpiredClaimCantTouchNewClaim(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	clientA, clientB := NewRESPClient(srv.addr()), NewRESPClient(srv.addr())
+	defer clientA.Close()
+	defer clientB.Close()
+	a, b := NewRedisStore(clientA, time.Hour), NewRedisStore(clientB, time.Hour)
+	ctx := context.Background()
+
+	// A's leases run out mid-dispatch, and B claims the redeliveries.
+	for _, id := range []string{"released", "completed"} {
+		_, _ = a.Claim(ctx, id, 20*time.Millisecond)
+	}
+	time.Sleep(40 * time.Millisecond)
+	for _, id := range []string{"released", "completed"} {
+		if ok, err := b.Claim(ctx, id, time.Minute); !ok || err != nil {
+			t.Fatalf("expected B to claim the expired %s event, got %v, %v", id, ok, err)
+		}
+	}
+
+	_ = a.Release(ctx, "released")
+	_ = a.Complete(ctx, "completed")
+	for _, id := range []string{"released", "completed"} {
+		v, _ := clientB.Do(ctx, "GET", DefaultRedisPrefix+id)
+		if v == nil || v == redisDone {
+			t.Fatalf("expected A's late settle to leave B's claim on %s, got %v", id, v)
+		}
+	}
+
+	_ = b.Complete(ctx, "completed")
+	if exists, _ := b.Exists(ctx, "completed"); !exists {
+		t.Fatal("expected B's complete to mark the event done")
+	}
+}
+
+func TestRedisStore_TTL(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	client := NewRESPClient(srv.addr())
+	defer client.Close()
+	store := NewRedisStore(client, 30*time.Millisecond)
+	ctx := context.Background()
+
+	_ = store.Store(ctx, "done")
+	_, _ = store.Claim(ctx, "claimed", 30*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	if exists, _ := store.Exists(ctx, "done"); exists {
+		t.Fatal("expected completed event to expire")
+	}
+	if ok, _ := store.Claim(ctx, "claimed", time.Minute); !ok {
+		t.Fatal("expected expired claim to be claimable")
+	}
+}
+
+func TestRedisStore_ShopKeys(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	client := NewRESPClient(srv.addr())
+	defer client.Close()
+	store := NewRedisStore(client, time.Hour, WithRedisPrefix("wh:"), WithRedisShopKeys())
+
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error { return nil })
+	handler := Handler("test-secret", router, WithIdempotencyStore(store))
+	handler.ServeHTTP(httptest.NewRecorder(), signedRequest("test-secret", `{"id":1}`, TopicOrdersCreate))
+
+	keys := srv.keys()
+	if len(keys) != 1 || keys[0] != "wh:test.myshopify.com:event-123" {
+		t.Fatalf("expected shop-scoped key, got %v", keys)
+	}
+}
+
+func TestRedisStore_WithHandler(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	client := NewRESPClient(srv.addr())
+	defer client.Close()
+	store := NewRedisStore(client, time.Hour)
+
+	var calls atomic.Int32
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls.Add(1)
+		return nil
+	})
+	handler := Handler("test-secret", router, WithIdempotencyStore(store))
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), signedRequest("test-secret", `{"id":1}`, TopicOrdersCreate))
+		}()
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 dispatch, got %d", got)
+	}
+}
+
+func TestRESPClient_Auth(t *testing.T) {
+	srv := startFakeRedis(t, "hunter2")
+	ctx := context.Background()
+
+	client := NewRESPClient(srv.addr())
+	defer client.Close()
+	_, err := client.Do(ctx, "GET", "k")
+	var redisErr *RedisError
+	if !errors.As(err, &redisErr) || !strings.HasPrefix(redisErr.Message, "NOAUTH") {
+		t.Fatalf("expected NOAUTH error, got %v", err)
+	}
+
+	authed := NewRESPClient(srv.addr(), WithRESPPassword("hunter2"), WithRESPDatabase(1))
+	defer authed.Close()
+	if _, err := authed.Do(ctx, "SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := authed.Do(ctx, "GET", "k"); err != nil || v != "v" {
+		t.Fatalf("expected v, got %v, %v", v, err)
+	}
+}
+
+func TestRESPClient_ErrorReplyKeepsConnection(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	client := NewRESPClient(srv.addr(), WithRESPPoolSize(1))
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, err := client.Do(ctx, "NOPE"); err == nil {
+		t.Fatal("expected error reply")
+	}
+	if v, err := client.Do(ctx, "PING"); err != nil || v != "OK" {
+		t.Fatalf("expected connection to stay usable, got %v, %v", v, err)
+	}
+}
+
+func TestRESPClient_DialError(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	store := NewRedisStore(NewRESPClient(addr, WithRESPTimeout(time.Second)), time.Hour)
+	if _, err := store.Claim(context.Background(), "evt", time.Minute); err == nil {
+		t.Fatal("expected error when Redis is unreachable")
+	}
+}
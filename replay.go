@@ -0,0 +1,66 @@
+package shopifywebhook
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReplayWindow rejects (or flags) events whose X-Shopify-Triggered-At
+// timestamp is too old or too far in the future, limiting how long a
+// captured, validly signed request can be replayed.
+//
+// Shopify keeps the original triggered-at timestamp when it retries a
+// delivery, and retries for up to 48 hours. A MaxAge shorter than that will
+// also catch legitimate late retries; use FlagOnly, or a custom response
+// that acknowledges them, if those should not be retried again.
+type ReplayWindow struct {
+	// MaxAge is how long after being triggered an event is accepted.
+	MaxAge time.Duration
+
+	// ClockSkew is how far in the future a triggered-at timestamp may be,
+	// to tolerate clock differences between Shopify and this server.
+	ClockSkew time.Duration
+
+	// FlagOnly accepts events outside the window but sets Metadata.Stale
+	// instead of rejecting them.
+	FlagOnly bool
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Check returns an error wrapping ErrStaleEvent, ErrFutureEvent or
+// ErrInvalidTriggeredAt if meta falls outside the window.
+func (rw ReplayWindow) Check(meta Metadata) error {
+	if meta.TriggeredAt.IsZero() {
+		return ErrInvalidTriggeredAt
+	}
+
+	now := time.Now()
+	if rw.Now != nil {
+		now = rw.Now()
+	}
+
+	age := now.Sub(meta.TriggeredAt)
+	if age > rw.MaxAge {
+		return fmt.Errorf("%w: triggered %v ago", ErrStaleEvent, age.Truncate(time.Second))
+	}
+	if -age > rw.ClockSkew {
+		return fmt.Errorf("%w: triggered %v in the future", ErrFutureEvent, (-age).Truncate(time.Second))
+	}
+	return nil
+}
+
+// apply checks meta against the window. In FlagOnly mode it marks meta as
+// stale and returns nil; otherwise it returns the check error.
+func (rw *ReplayWindow) apply(meta *Metadata) error {
+	if rw == nil {
+		return nil
+	}
+	err := rw.Check(*meta)
+	if err != nil && rw.FlagOnly {
+		meta.Stale = true
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package shopifywebhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplayWindow_Check(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	rw := ReplayWindow{
+		MaxAge:    5 * time.Minute,
+		ClockSkew: 30 * time.Second,
+		Now:       func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name        string
+		triggeredAt time.Time
+		want        error
+	}{
+		{"fresh", now.Add(-time.Minute), nil},
+		{"at max age", now.Add(-5 * time.Minute), nil},
+		{"stale", now.Add(-6 * time.Minute), ErrStaleEvent},
+		{"within skew", now.Add(20 * time.Second), nil},
+		{"future", now.Add(time.Minute), ErrFutureEvent},
+		{"missing", time.Time{}, ErrInvalidTriggeredAt},
+	}
+	for _, tt := range tests {
+		err := rw.Check(Metadata{TriggeredAt: tt.triggeredAt})
+		if tt.want == nil && err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestHandler_ReplayWindow_RejectsStale(t *testing.T) {
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		t.Fatal("handler should not be called for stale event")
+		return nil
+	})
+
+	handler := Handler("secret", router, WithHandlerReplayWindow(ReplayWindow{MaxAge: time.Minute}))
+	req := signedRequest("secret", `{}`, TopicOrdersCreate)
+	req.Header.Set("X-Shopify-Triggered-At", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestHandler_ReplayWindow_CustomResponse(t *testing.T) {
+	var gotErr error
+	handler := Handler("secret", NewRouter(),
+		WithHandlerReplayWindow(ReplayWindow{MaxAge: time.Minute}),
+		WithHandlerReplayErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	req := signedRequest("secret", `{}`, TopicOrdersCreate)
+	req.Header.Set("X-Shopify-Triggered-At", "not-a-timestamp")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected custom 200, got %d", rr.Code)
+	}
+	if !errors.Is(gotErr, ErrInvalidTriggeredAt) {
+		t.Fatalf("expected ErrInvalidTriggeredAt, got: %v", gotErr)
+	}
+}
+
+func TestHandler_ReplayWindow_Fresh(t *testing.T) {
+	var called bool
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		called = true
+		return nil
+	})
+
+	handler := Handler("secret", router, WithHandlerReplayWindow(ReplayWindow{MaxAge: time.Minute}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("secret", `{}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusOK || !called {
+		t.Fatalf("expected fresh event to be dispatched, got %d called=%v", rr.Code, called)
+	}
+}
+
+func TestMiddleware_ReplayWindow_FlagOnly(t *testing.T) {
+	var stale bool
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event, _ := EventFromContext(r.Context())
+		stale = event.Metadata.Stale
+	})
+
+	handler := Middleware("secret", WithReplayWindow(ReplayWindow{
+		MaxAge:   time.Minute,
+		FlagOnly: true,
+	}))(inner)
+
+	req := signedRequest("secret", `{}`, TopicOrdersCreate)
+	req.Header.Set("X-Shopify-Triggered-At", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !stale {
+		t.Fatal("expected event to be flagged as stale")
+	}
+}
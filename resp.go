@@ -0,0 +1,287 @@
+package shopifywebhook
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisClient runs a single Redis command. It is the only thing RedisStore
+// needs from a Redis client, so any client library can be adapted to it.
+//
+// Replies map to Go values as follows: simple and bulk strings to string,
+// integers to int64, arrays to []any, and a null reply to a nil value with
+// a nil error. Error replies are returned as errors.
+//
+// For github.com/redis/go-redis, adapt a client with:
+//
+//	shopifywebhook.RedisClientFunc(func(ctx context.Context, args ...any) (any, error) {
+//	    v, err := rdb.Do(ctx, args...).Result()
+//	    if errors.Is(err, redis.Nil) {
+//	        return nil, nil
+//	    }
+//	    return v, err
+//	})
+type RedisClient interface {
+	Do(ctx context.Context, args ...any) (any, error)
+}
+
+// RedisClientFunc adapts a function to the RedisClient interface.
+type RedisClientFunc func(ctx context.Context, args ...any) (any, error)
+
+// Do calls f(ctx, args...).
+func (f RedisClientFunc) Do(ctx context.Context, args ...any) (any, error) {
+	return f(ctx, args...)
+}
+
+// RESPClient is a minimal, dependency-free RedisClient that speaks the
+// Redis serialization protocol (RESP2) over TCP. It keeps a small pool of
+// connections and is safe for concurrent use.
+//
+// It supports what RedisStore needs and little more: no pipelining,
+// pub/sub, cluster or TLS. Use a full client library through RedisClient
+// if you need those.
+type RESPClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	pool     chan *respConn
+}
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// RESPOption configures a RESPClient.
+type RESPOption func(*RESPClient)
+
+// WithRESPPassword authenticates new connections with AUTH.
+func WithRESPPassword(password string) RESPOption {
+	return func(c *RESPClient) {
+		c.password = password
+	}
+}
+
+// WithRESPDatabase selects the database number on new connections.
+func WithRESPDatabase(db int) RESPOption {
+	return func(c *RESPClient) {
+		c.db = db
+	}
+}
+
+// WithRESPPoolSize sets how many idle connections are kept. Default: 4.
+func WithRESPPoolSize(n int) RESPOption {
+	return func(c *RESPClient) {
+		c.pool = make(chan *respConn, n)
+	}
+}
+
+// WithRESPTimeout bounds dialing and each command when the context has no
+// earlier deadline. Default: 5s.
+func WithRESPTimeout(d time.Duration) RESPOption {
+	return func(c *RESPClient) {
+		c.timeout = d
+	}
+}
+
+// NewRESPClient creates a client for the Redis server at addr
+// ("host:port"). Connections are opened lazily.
+func NewRESPClient(addr string, opts ...RESPOption) *RESPClient {
+	c := &RESPClient{
+		addr:    addr,
+		timeout: 5 * time.Second,
+		pool:    make(chan *respConn, 4),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Do sends a command and reads its reply. Arguments are formatted as bulk
+// strings: strings and []byte as is, other values with fmt.Sprint.
+func (c *RESPClient) Do(ctx context.Context, args ...any) (any, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, c.timeout, args)
+	var redisErr *RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection may be mid-reply; don't reuse it.
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+// Close closes idle connections. Connections in use are closed when their
+// command completes.
+func (c *RESPClient) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *RESPClient) get(ctx context.Context) (*respConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.timeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("shopifywebhook: redis dial: %w", err)
+	}
+	conn := &respConn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]any
+	if c.password != "" {
+		setup = append(setup, []any{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []any{"SELECT", c.db})
+	}
+	for _, args := range setup {
+		if _, err := conn.do(ctx, c.timeout, args); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *RESPClient) put(conn *respConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+func (rc *respConn) do(ctx context.Context, timeout time.Duration, args []any) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if err := writeRESPCommand(rc.w, args); err != nil {
+		return nil, fmt.Errorf("shopifywebhook: redis write: %w", err)
+	}
+	reply, err := readRESPReply(rc.r)
+	if err != nil {
+		var redisErr *RedisError
+		if errors.As(err, &redisErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("shopifywebhook: redis read: %w", err)
+	}
+	return reply, nil
+}
+
+func writeRESPCommand(w *bufio.Writer, args []any) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			s = fmt.Sprint(v)
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return w.Flush()
+}
+
+// readRESPReply reads one reply. Error replies are returned as *RedisError;
+// all other errors mean the stream can no longer be trusted.
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, &RedisError{Message: line[1:]}
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// Read every element before reporting an error reply inside the
+		// array, so the stream stays in sync.
+		items := make([]any, n)
+		var firstErr error
+		for i := range items {
+			item, err := readRESPReply(r)
+			var redisErr *RedisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			items[i] = item
+		}
+		return items, firstErr
+	default:
+		return nil, fmt.Errorf("unexpected reply type %q", line[0])
+	}
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,162 @@
+package shopifywebhook
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides whether and when a WorkerPool retries a failed event.
+// Set one per pool with WithRetryPolicy, or per topic with
+// WithTopicRetryPolicy.
+type RetryPolicy interface {
+	// NextRetry is called after each failed attempt. attempt is the number
+	// of attempts made so far (1 after the first failure), lastDelay the
+	// delay before the failed attempt (0 for the first), and err its error.
+	// It returns the delay before the next attempt, or false to give up.
+	NextRetry(attempt int, lastDelay time.Duration, err error) (time.Duration, bool)
+}
+
+// RetryPolicyFunc adapts a function to the RetryPolicy interface.
+type RetryPolicyFunc func(attempt int, lastDelay time.Duration, err error) (time.Duration, bool)
+
+// NextRetry calls f(attempt, lastDelay, err).
+func (f RetryPolicyFunc) NextRetry(attempt int, lastDelay time.Duration, err error) (time.Duration, bool) {
+	return f(attempt, lastDelay, err)
+}
+
+// NoRetry is a RetryPolicy that never retries.
+var NoRetry RetryPolicy = RetryPolicyFunc(func(int, time.Duration, error) (time.Duration, bool) {
+	return 0, false
+})
+
+// Jitter selects how ExponentialBackoff randomizes its delays.
+type Jitter int
+
+const (
+	// NoJitter uses the exponential delay as is.
+	NoJitter Jitter = iota
+
+	// FullJitter picks a delay uniformly between 0 and the exponential
+	// delay, spreading out retries of events that failed together.
+	FullJitter
+
+	// DecorrelatedJitter picks a delay uniformly between BaseDelay and
+	// three times the previous delay, growing roughly exponentially
+	// without the attempt count.
+	DecorrelatedJitter
+)
+
+// ExponentialBackoff is a RetryPolicy that retries retryable errors (see
+// IsRetryable) up to MaxRetries times, waiting BaseDelay * 2^n before the
+// nth retry, capped at MaxDelay.
+type ExponentialBackoff struct {
+	// MaxRetries is how many times an event is retried after its first
+	// attempt fails.
+	MaxRetries int
+
+	// BaseDelay is the delay before the first retry. Default: 500ms.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between attempts. Zero means no cap.
+	MaxDelay time.Duration
+
+	// Jitter randomizes the delays. Default: NoJitter.
+	Jitter Jitter
+}
+
+// NextRetry implements RetryPolicy.
+func (b ExponentialBackoff) NextRetry(attempt int, lastDelay time.Duration, err error) (time.Duration, bool) {
+	if attempt > b.MaxRetries || !IsRetryable(err) {
+		return 0, false
+	}
+
+	base := b.BaseDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+
+	// Without MaxDelay, still cap delays well short of overflowing, so
+	// doubling and the jitter bounds stay positive.
+	limit := time.Duration(maxBackoffDelay)
+	if b.MaxDelay > 0 {
+		limit = min(b.MaxDelay, limit)
+	}
+
+	var delay time.Duration
+	switch b.Jitter {
+	case DecorrelatedJitter:
+		upper := max(3*min(lastDelay, limit/3), base)
+		delay = base + rand.N(upper-base+1)
+	default:
+		// Exponential backoff: 500ms, 1s, 2s, 4s, ... Stop doubling once
+		// past the cap so large attempt counts can't overflow.
+		delay = min(base, limit)
+		for range attempt - 1 {
+			if delay >= limit {
+				break
+			}
+			delay *= 2
+		}
+		delay = min(delay, limit)
+		if b.Jitter == FullJitter {
+			delay = rand.N(delay + 1)
+		}
+	}
+
+	return min(delay, limit), true
+}
+
+// maxBackoffDelay caps ExponentialBackoff's delays when MaxDelay is unset.
+const maxBackoffDelay = math.MaxInt64 / 4
+
+// Permanent wraps err so that ExponentialBackoff doesn't retry it. Return
+// it from a handler for failures such as invalid data:
+//
+//	if order.Email == "" {
+//	    return shopifywebhook.Permanent(errors.New("order has no email"))
+//	}
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// Retryable wraps err so that ExponentialBackoff retries it even if it
+// would otherwise be classified as permanent.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err}
+}
+
+// IsRetryable reports whether err is worth retrying. The outermost
+// Permanent or Retryable marker in err's chain decides; without one,
+// a *DecodeError is permanent, as the payload will never decode, and
+// everything else is retryable.
+//
+// When the chain joins several errors, as errors.Join and *FanoutError do,
+// err is retryable if any of them is: retrying the event gives the ones
+// that failed transiently another chance.
+func IsRetryable(err error) bool {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e := e.(type) {
+		case *PermanentError:
+			return false
+		case *RetryableError:
+			return true
+		case interface{ Unwrap() []error }:
+			for _, joined := range e.Unwrap() {
+				if joined != nil && IsRetryable(joined) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	var decodeErr *DecodeError
+	return !errors.As(err, &decodeErr)
+}
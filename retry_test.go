@@ -0,0 +1,107 @@
+package shopifywebhook
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff_Schedule(t *testing.T) {
+	b := ExponentialBackoff{MaxRetries: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	err := errors.New("transient")
+
+	want := []time.Duration{100, 200, 400, 800, 1000}
+	var last time.Duration
+	for i, w := range want {
+		delay, ok := b.NextRetry(i+1, last, err)
+		if !ok || delay != w*time.Millisecond {
+			t.Fatalf("attempt %d: expected %v, got %v (retry=%v)", i+1, w*time.Millisecond, delay, ok)
+		}
+		last = delay
+	}
+	if _, ok := b.NextRetry(6, last, err); ok {
+		t.Fatal("expected no retry after MaxRetries")
+	}
+}
+
+func TestExponentialBackoff_LargeAttemptDoesNotOverflow(t *testing.T) {
+	b := ExponentialBackoff{MaxRetries: 1000, BaseDelay: time.Second, MaxDelay: time.Hour}
+	if delay, _ := b.NextRetry(500, 0, errors.New("x")); delay != time.Hour {
+		t.Fatalf("expected delay capped at 1h, got %v", delay)
+	}
+}
+
+func TestExponentialBackoff_LargeAttemptWithoutMaxDelay(t *testing.T) {
+	err := errors.New("x")
+	for _, jitter := range []Jitter{NoJitter, FullJitter, DecorrelatedJitter} {
+		b := ExponentialBackoff{MaxRetries: 100, Jitter: jitter}
+		last := time.Duration(0)
+		for attempt := 1; attempt <= 64; attempt++ {
+			delay, ok := b.NextRetry(attempt, last, err)
+			if !ok || delay < 0 {
+				t.Fatalf("jitter %d, attempt %d: expected a non-negative delay, got %v (retry=%v)", jitter, attempt, delay, ok)
+			}
+			last = delay
+		}
+		if jitter == NoJitter && last < 1000*time.Hour {
+			t.Fatalf("expected the delay to keep growing, got %v", last)
+		}
+	}
+}
+
+func TestExponentialBackoff_Jitter(t *testing.T) {
+	err := errors.New("transient")
+
+	full := ExponentialBackoff{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, Jitter: FullJitter}
+	for range 100 {
+		if delay, _ := full.NextRetry(3, 0, err); delay < 0 || delay > 400*time.Millisecond {
+			t.Fatalf("full jitter out of range: %v", delay)
+		}
+	}
+
+	decorrelated := ExponentialBackoff{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: DecorrelatedJitter}
+	for range 100 {
+		delay, _ := decorrelated.NextRetry(3, 200*time.Millisecond, err)
+		if delay < 100*time.Millisecond || delay > 600*time.Millisecond {
+			t.Fatalf("decorrelated jitter out of range: %v", delay)
+		}
+		if delay, _ := decorrelated.NextRetry(3, time.Second, err); delay > time.Second {
+			t.Fatalf("decorrelated jitter above MaxDelay: %v", delay)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	base := errors.New("boom")
+	decode := &DecodeError{Topic: TopicOrdersCreate, Type: "Order", Err: base}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"plain", base, true},
+		{"permanent", Permanent(base), false},
+		{"wrapped permanent", fmt.Errorf("handler: %w", Permanent(base)), false},
+		{"decode", decode, false},
+		{"retryable decode", Retryable(decode), true},
+		{"permanent over retryable", Permanent(Retryable(base)), false},
+		{"fanout all permanent", &FanoutError{Errors: []*SubscriberError{{Err: Permanent(base)}, {Err: decode}}}, false},
+		{"fanout one retryable", &FanoutError{Errors: []*SubscriberError{{Err: Permanent(base)}, {Err: base}}}, true},
+		{"permanent fanout", Permanent(&FanoutError{Errors: []*SubscriberError{{Err: base}}}), false},
+		{"joined all permanent", errors.Join(Permanent(base), decode), false},
+		{"joined one retryable", errors.Join(Permanent(base), base), true},
+		{"wrapped join", fmt.Errorf("sync: %w", errors.Join(Permanent(base), Permanent(base))), false},
+		{"two wrapped errors", fmt.Errorf("%w and %w", decode, Retryable(base)), true},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if Permanent(nil) != nil || Retryable(nil) != nil {
+		t.Fatal("expected nil errors to stay nil")
+	}
+}
@@ -1,22 +1,83 @@
 package shopifywebhook
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Router dispatches webhook events to registered handlers by topic.
+//
+// Handlers can be registered for exact topics ("orders/create") or for
+// patterns where "*" matches one segment ("orders/*", "*/delete"). An event
+// is routed to, in order of precedence:
+//
+//  1. the handler for its exact topic
+//  2. the most specific matching pattern (see Topic.Match); at the first
+//     segment where two patterns differ, a literal beats a wildcard, so
+//     "orders/*" is preferred over "*/delete", which is preferred over "*/*"
+//  3. the Fallback handler
+//
+// By default each topic has a single handler. WithFanout enables several
+// subscribers per topic.
 type Router struct {
-	mu       sync.RWMutex
-	handlers map[Topic]HandlerFunc
-	fallback HandlerFunc
-	onError  ErrorHandlerFunc
+	mu             sync.RWMutex
+	handlers       map[Topic][]subscriber
+	patterns       []Topic // registered patterns in precedence order
+	fallback       ContextHandlerFunc
+	onError        ErrorHandlerFunc
+	timeouts       map[Topic]time.Duration
+	defaultTimeout time.Duration
+	middleware     []DispatchMiddleware
+	fanout         FanoutMode
+}
+
+type subscriber struct {
+	name    string
+	handler ContextHandlerFunc
+}
+
+// DispatchMiddleware wraps a handler to add behaviour around dispatch,
+// such as logging, tracing or metrics.
+//
+//	func logging(next shopifywebhook.ContextHandlerFunc) shopifywebhook.ContextHandlerFunc {
+//	    return func(ctx context.Context, event shopifywebhook.Event) error {
+//	        start := time.Now()
+//	        err := next(ctx, event)
+//	        log.Printf("%s took %v", event.Metadata.Topic, time.Since(start))
+//	        return err
+//	    }
+//	}
+type DispatchMiddleware func(next ContextHandlerFunc) ContextHandlerFunc
+
+// AdaptMiddleware converts middleware written against HandlerFunc into a
+// DispatchMiddleware. The dispatch context is carried through to the next
+// handler unchanged.
+func AdaptMiddleware(mw func(next HandlerFunc) HandlerFunc) DispatchMiddleware {
+	return func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx context.Context, event Event) error {
+			return mw(func(event Event) error {
+				return next(ctx, event)
+			})(event)
+		}
+	}
+}
+
+// Registrar is implemented by Router and Group, allowing helpers such as
+// HandleTyped to register handlers on either.
+type Registrar interface {
+	HandleContext(topic Topic, handler ContextHandlerFunc)
 }
 
 // NewRouter creates a new Router with the given options.
 func NewRouter(opts ...RouterOption) *Router {
 	r := &Router{
-		handlers: make(map[Topic]HandlerFunc),
+		handlers: make(map[Topic][]subscriber),
+		timeouts: make(map[Topic]time.Duration),
 	}
 	for _, opt := range opts {
 		opt(r)
@@ -24,21 +85,71 @@ func NewRouter(opts ...RouterOption) *Router {
 	return r
 }
 
-// Handle registers a handler for a specific webhook topic.
+// Handle registers a handler for a specific webhook topic or topic pattern.
 // Panics if a handler is already registered for the topic — this catches
-// configuration mistakes at startup.
+// configuration mistakes at startup — unless the Router was created with
+// WithFanout, in which case the handler is added as another subscriber.
 func (r *Router) Handle(topic Topic, handler HandlerFunc) {
+	r.HandleContext(topic, AdaptHandler(handler))
+}
+
+// HandleContext registers a context-aware handler for a specific webhook
+// topic or topic pattern. Panics if a handler is already registered for
+// the topic (unless fan-out is enabled), or if a pattern uses "*" other
+// than as a whole segment.
+func (r *Router) HandleContext(topic Topic, handler ContextHandlerFunc) {
+	r.Subscribe(topic, "", handler)
+}
+
+// Subscribe registers a named handler for a topic or topic pattern. The
+// name identifies the subscriber in a *FanoutError; if empty, the
+// subscriber's position ("#0", "#1", ...) is used instead.
+//
+// Without WithFanout, Subscribe behaves like HandleContext and panics if
+// the topic already has a handler.
+func (r *Router) Subscribe(topic Topic, name string, handler ContextHandlerFunc) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, exists := r.handlers[topic]; exists {
+	subs, exists := r.handlers[topic]
+	if exists && r.fanout == fanoutOff {
 		panic(fmt.Sprintf("shopifywebhook: handler already registered for topic %q", topic))
 	}
-	r.handlers[topic] = handler
+	if !exists && topic.IsPattern() {
+		validatePattern(topic)
+		r.patterns = append(r.patterns, topic)
+		sort.Slice(r.patterns, func(i, j int) bool {
+			return morePrecise(r.patterns[i], r.patterns[j])
+		})
+	}
+	if name == "" {
+		name = fmt.Sprintf("#%d", len(subs))
+	}
+	r.handlers[topic] = append(subs, subscriber{name: name, handler: handler})
+}
+
+// route returns the registered topic or pattern that handles topic.
+// Must be called with r.mu held.
+func (r *Router) route(topic Topic) (Topic, bool) {
+	if _, ok := r.handlers[topic]; ok {
+		return topic, true
+	}
+	for _, pattern := range r.patterns {
+		if pattern.Match(topic) {
+			return pattern, true
+		}
+	}
+	return "", false
 }
 
 // Fallback sets a handler for topics without a registered handler.
 // If not set, unhandled topics cause Dispatch to return ErrUnhandledTopic.
 func (r *Router) Fallback(handler HandlerFunc) {
+	r.FallbackContext(AdaptHandler(handler))
+}
+
+// FallbackContext sets a context-aware handler for topics without a
+// registered handler.
+func (r *Router) FallbackContext(handler ContextHandlerFunc) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.fallback = handler
@@ -48,22 +159,76 @@ func (r *Router) Fallback(handler HandlerFunc) {
 //
 // This is called internally by the Handler, but is also exported for use
 // outside HTTP contexts (e.g., replaying events from a database or queue).
+// It is equivalent to DispatchContext with context.Background().
 func (r *Router) Dispatch(event Event) error {
+	return r.DispatchContext(context.Background(), event)
+}
+
+// RetryFailed re-dispatches an event after a previous attempt returned err.
+// If err is a *FanoutError, only the subscribers that failed are run again;
+// otherwise the event is dispatched as normal.
+func (r *Router) RetryFailed(ctx context.Context, event Event, err error) error {
+	var fanoutErr *FanoutError
+	if !errors.As(err, &fanoutErr) {
+		return r.DispatchContext(ctx, event)
+	}
+	return r.dispatch(ctx, event, fanoutErr.failed())
+}
+
+// DispatchContext routes an event to the appropriate handler, passing ctx
+// through to context-aware handlers. If a timeout is configured for the
+// event's topic, the handler's context is bounded by it.
+//
+// A panic in the handler or its middleware is recovered and returned as a
+// *PanicError.
+//
+// When a topic has several subscribers, each runs with the Router's
+// middleware and any failures are returned together as a *FanoutError.
+func (r *Router) DispatchContext(ctx context.Context, event Event) error {
+	return r.dispatch(ctx, event, nil)
+}
+
+// dispatch runs the subscribers for event. If only is non-nil, just the
+// subscribers at those indices are run.
+func (r *Router) dispatch(ctx context.Context, event Event, only []int) error {
 	r.mu.RLock()
-	handler, ok := r.handlers[event.Metadata.Topic]
+	matched, ok := r.route(event.Metadata.Topic)
+	subs := r.handlers[matched]
 	fallback := r.fallback
 	onError := r.onError
+	timeout, hasTimeout := r.timeouts[event.Metadata.Topic]
+	if !hasTimeout {
+		timeout, hasTimeout = r.timeouts[matched]
+	}
+	if !hasTimeout {
+		timeout = r.defaultTimeout
+	}
+	middleware := r.middleware
+	mode := r.fanout
 	r.mu.RUnlock()
 
 	if !ok {
 		if fallback != nil {
-			handler = fallback
+			subs = []subscriber{{name: "fallback", handler: fallback}}
 		} else {
 			return fmt.Errorf("%w: %s", ErrUnhandledTopic, event.Metadata.Topic)
 		}
 	}
 
-	if err := handler(event); err != nil {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var err error
+	if len(subs) == 1 {
+		err = callHandler(ctx, chain(middleware, subs[0].handler), event)
+	} else {
+		err = runFanout(ctx, event, subs, middleware, mode, only)
+	}
+
+	if err != nil {
 		if onError != nil {
 			onError(event, err)
 		}
@@ -72,15 +237,52 @@ func (r *Router) Dispatch(event Event) error {
 	return nil
 }
 
-// Topics returns a list of all registered topics.
+// callHandler runs handler, converting a panic into a *PanicError.
+func callHandler(ctx context.Context, handler ContextHandlerFunc, event Event) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack(), Event: event}
+		}
+	}()
+	return handler(ctx, event)
+}
+
+// Use appends middleware applied to every dispatched event, including
+// events handled by the Fallback. Middleware runs in the order given, so
+// the first one is outermost. Router middleware wraps any group middleware.
+func (r *Router) Use(middleware ...DispatchMiddleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware[:len(r.middleware):len(r.middleware)], middleware...)
+}
+
+// With returns a Group that registers handlers on r wrapped in the given
+// middleware. Use it for per-topic stacks:
+//
+//	router.With(audit).Handle(shopifywebhook.TopicOrdersPaid, handlePaid)
+//
+// or for a set of related topics:
+//
+//	orders := router.With(tracing, metrics)
+//	orders.Handle(shopifywebhook.TopicOrdersCreate, handleCreate)
+//	orders.Handle(shopifywebhook.TopicOrdersUpdate, handleUpdate)
+func (r *Router) With(middleware ...DispatchMiddleware) *Group {
+	return &Group{router: r, middleware: middleware}
+}
+
+// Topics returns a list of all registered topics, including patterns.
+// Exact topics come first; patterns follow in precedence order.
+// Use Topic.IsPattern to tell them apart.
 func (r *Router) Topics() []Topic {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	topics := make([]Topic, 0, len(r.handlers))
 	for t := range r.handlers {
-		topics = append(topics, t)
+		if !t.IsPattern() {
+			topics = append(topics, t)
+		}
 	}
-	return topics
+	return append(topics, r.patterns...)
 }
 
 // RouterOption configures a Router.
@@ -92,3 +294,81 @@ func WithErrorHandler(fn ErrorHandlerFunc) RouterOption {
 		r.onError = fn
 	}
 }
+
+// WithFanout lets several handlers subscribe to the same topic. Handle,
+// HandleContext and Subscribe add a subscriber instead of panicking when
+// the topic is already registered. mode selects whether subscribers run
+// one after another or concurrently.
+func WithFanout(mode FanoutMode) RouterOption {
+	return func(r *Router) {
+		r.fanout = mode
+	}
+}
+
+// WithHandlerTimeout bounds the context passed to every handler.
+// Topics configured with WithTopicTimeout use their own value instead.
+// Default: no timeout.
+func WithHandlerTimeout(d time.Duration) RouterOption {
+	return func(r *Router) {
+		r.defaultTimeout = d
+	}
+}
+
+// WithTopicTimeout bounds the context passed to the handler for a single
+// topic, overriding WithHandlerTimeout. topic may be a pattern, in which
+// case it applies to events routed through that pattern unless the exact
+// topic has its own timeout. A zero duration disables the timeout.
+func WithTopicTimeout(topic Topic, d time.Duration) RouterOption {
+	return func(r *Router) {
+		r.timeouts[topic] = d
+	}
+}
+
+// Group registers handlers on a Router with its own middleware stack.
+// Create one with Router.With.
+type Group struct {
+	router     *Router
+	middleware []DispatchMiddleware
+}
+
+// Handle registers a handler for a topic, wrapped in the group's middleware.
+// Panics if a handler is already registered for the topic.
+func (g *Group) Handle(topic Topic, handler HandlerFunc) {
+	g.HandleContext(topic, AdaptHandler(handler))
+}
+
+// HandleContext registers a context-aware handler for a topic, wrapped in
+// the group's middleware. Panics if a handler is already registered for
+// the topic, unless fan-out is enabled.
+func (g *Group) HandleContext(topic Topic, handler ContextHandlerFunc) {
+	g.router.HandleContext(topic, chain(g.middleware, handler))
+}
+
+// Subscribe registers a named subscriber for a topic, wrapped in the
+// group's middleware. See Router.Subscribe.
+func (g *Group) Subscribe(topic Topic, name string, handler ContextHandlerFunc) {
+	g.router.Subscribe(topic, name, chain(g.middleware, handler))
+}
+
+// Use appends middleware to the group. It only affects handlers registered
+// on the group afterwards.
+func (g *Group) Use(middleware ...DispatchMiddleware) {
+	g.middleware = append(g.middleware[:len(g.middleware):len(g.middleware)], middleware...)
+}
+
+// With returns a nested Group whose stack is this group's middleware
+// followed by the given middleware.
+func (g *Group) With(middleware ...DispatchMiddleware) *Group {
+	stack := make([]DispatchMiddleware, 0, len(g.middleware)+len(middleware))
+	stack = append(stack, g.middleware...)
+	stack = append(stack, middleware...)
+	return &Group{router: g.router, middleware: stack}
+}
+
+// chain wraps handler so that middleware[0] is the outermost layer.
+func chain(middleware []DispatchMiddleware, handler ContextHandlerFunc) ContextHandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
@@ -1,9 +1,11 @@
 package shopifywebhook
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestRouter_Handle_And_Dispatch(t *testing.T) {
@@ -132,3 +134,327 @@ func TestRouter_HandlerReturnsNil(t *testing.T) {
 		t.Fatalf("expected nil error, got: %v", err)
 	}
 }
+
+func TestRouter_DispatchContext_PassesContext(t *testing.T) {
+	type ctxKey struct{}
+	router := NewRouter()
+
+	var got any
+	router.HandleContext(TopicOrdersCreate, func(ctx context.Context, event Event) error {
+		got = ctx.Value(ctxKey{})
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request-scoped")
+	if err := router.DispatchContext(ctx, Event{Metadata: Metadata{Topic: TopicOrdersCreate}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "request-scoped" {
+		t.Fatalf("expected context value to reach handler, got %v", got)
+	}
+}
+
+func TestRouter_TopicTimeout(t *testing.T) {
+	router := NewRouter(
+		WithHandlerTimeout(time.Hour),
+		WithTopicTimeout(TopicOrdersCreate, 20*time.Millisecond),
+	)
+
+	router.HandleContext(TopicOrdersCreate, func(ctx context.Context, event Event) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	var deadline time.Time
+	router.HandleContext(TopicProductsUpdate, func(ctx context.Context, event Event) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	})
+
+	err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersCreate}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got: %v", err)
+	}
+
+	if err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicProductsUpdate}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Until(deadline) < 59*time.Minute {
+		t.Fatalf("expected default timeout of ~1h, got deadline in %v", time.Until(deadline))
+	}
+}
+
+func TestAdaptHandler(t *testing.T) {
+	var called bool
+	h := AdaptHandler(func(event Event) error {
+		called = true
+		return nil
+	})
+
+	if err := h(context.Background(), Event{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatal("expected wrapped handler to be called")
+	}
+}
+
+func recordMiddleware(name string, calls *[]string) DispatchMiddleware {
+	return func(next ContextHandlerFunc) ContextHandlerFunc {
+		return func(ctx context.Context, event Event) error {
+			*calls = append(*calls, name)
+			return next(ctx, event)
+		}
+	}
+}
+
+func TestRouter_Use_Order(t *testing.T) {
+	var calls []string
+	router := NewRouter()
+	router.Use(recordMiddleware("a", &calls), recordMiddleware("b", &calls))
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	if err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fmt.Sprint(calls); got != "[a b handler]" {
+		t.Fatalf("unexpected call order: %s", got)
+	}
+}
+
+func TestRouter_Use_AppliesToFallback(t *testing.T) {
+	var calls []string
+	router := NewRouter()
+	router.Use(recordMiddleware("mw", &calls))
+	router.Fallback(func(event Event) error {
+		calls = append(calls, "fallback")
+		return nil
+	})
+
+	if err := router.Dispatch(Event{Metadata: Metadata{Topic: "some/unknown_topic"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fmt.Sprint(calls); got != "[mw fallback]" {
+		t.Fatalf("unexpected call order: %s", got)
+	}
+}
+
+func TestRouter_With_GroupMiddleware(t *testing.T) {
+	var calls []string
+	router := NewRouter()
+	router.Use(recordMiddleware("router", &calls))
+
+	orders := router.With(recordMiddleware("orders", &calls))
+	orders.Handle(TopicOrdersCreate, func(event Event) error {
+		calls = append(calls, "create")
+		return nil
+	})
+	orders.With(recordMiddleware("paid", &calls)).Handle(TopicOrdersPaid, func(event Event) error {
+		calls = append(calls, "paid-handler")
+		return nil
+	})
+	router.Handle(TopicProductsUpdate, func(event Event) error {
+		calls = append(calls, "product")
+		return nil
+	})
+
+	tests := []struct {
+		topic Topic
+		want  string
+	}{
+		{TopicOrdersCreate, "[router orders create]"},
+		{TopicOrdersPaid, "[router orders paid paid-handler]"},
+		{TopicProductsUpdate, "[router product]"},
+	}
+	for _, tt := range tests {
+		calls = nil
+		if err := router.Dispatch(Event{Metadata: Metadata{Topic: tt.topic}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := fmt.Sprint(calls); got != tt.want {
+			t.Fatalf("%s: expected %s, got %s", tt.topic, tt.want, got)
+		}
+	}
+}
+
+func TestAdaptMiddleware(t *testing.T) {
+	type ctxKey struct{}
+	var sawMiddleware bool
+	router := NewRouter()
+	router.Use(AdaptMiddleware(func(next HandlerFunc) HandlerFunc {
+		return func(event Event) error {
+			sawMiddleware = true
+			return next(event)
+		}
+	}))
+
+	var got any
+	router.HandleContext(TopicOrdersCreate, func(ctx context.Context, event Event) error {
+		got = ctx.Value(ctxKey{})
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "kept")
+	if err := router.DispatchContext(ctx, Event{Metadata: Metadata{Topic: TopicOrdersCreate}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sawMiddleware || got != "kept" {
+		t.Fatalf("expected middleware to run and context to pass through, got %v", got)
+	}
+}
+
+func TestRouter_PanicRecovered(t *testing.T) {
+	var captured error
+	router := NewRouter(WithErrorHandler(func(event Event, err error) {
+		captured = err
+	}))
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		panic("boom")
+	})
+
+	event := Event{Metadata: Metadata{Topic: TopicOrdersCreate, EventID: "evt-1"}}
+	err := router.Dispatch(event)
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected *PanicError, got: %v", err)
+	}
+	if panicErr.Value != "boom" {
+		t.Fatalf("expected panic value %q, got %v", "boom", panicErr.Value)
+	}
+	if panicErr.Event.Metadata.EventID != "evt-1" {
+		t.Fatalf("expected event to be attached, got %+v", panicErr.Event.Metadata)
+	}
+	if len(panicErr.Stack) == 0 {
+		t.Fatal("expected stack trace")
+	}
+	if captured != err {
+		t.Fatalf("expected error handler to receive the panic error, got %v", captured)
+	}
+}
+
+func TestRouter_PanicWithErrorUnwraps(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		panic(sentinel)
+	})
+
+	err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersCreate}})
+	if !errors.Is(err, sentinel) {
+		t.Fatalf("expected panic error to unwrap to sentinel, got: %v", err)
+	}
+}
+
+func TestRouter_PatternPrecedence(t *testing.T) {
+	var got string
+	handler := func(name string) HandlerFunc {
+		return func(event Event) error {
+			got = name
+			return nil
+		}
+	}
+
+	router := NewRouter()
+	router.Handle("*/*", handler("any"))
+	router.Handle("*/delete", handler("any-delete"))
+	router.Handle("orders/*", handler("orders"))
+	router.Handle(TopicOrdersPaid, handler("paid"))
+	router.Fallback(handler("fallback"))
+
+	tests := []struct {
+		topic Topic
+		want  string
+	}{
+		{TopicOrdersPaid, "paid"},
+		{TopicOrdersCreate, "orders"},
+		{TopicOrdersDelete, "orders"},
+		{TopicProductsDelete, "any-delete"},
+		{TopicProductsUpdate, "any"},
+		{"some/deeply/nested", "fallback"},
+	}
+	for _, tt := range tests {
+		got = ""
+		if err := router.Dispatch(Event{Metadata: Metadata{Topic: tt.topic}}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.topic, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: expected %q handler, got %q", tt.topic, tt.want, got)
+		}
+	}
+}
+
+func TestRouter_PatternWithoutFallback(t *testing.T) {
+	router := NewRouter()
+	router.Handle("orders/*", func(event Event) error { return nil })
+
+	err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicProductsCreate}})
+	if !errors.Is(err, ErrUnhandledTopic) {
+		t.Fatalf("expected ErrUnhandledTopic, got: %v", err)
+	}
+}
+
+func TestRouter_InvalidPattern_Panics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on invalid pattern")
+		}
+	}()
+	NewRouter().Handle("orders/cre*", func(event Event) error { return nil })
+}
+
+func TestRouter_Topics_IncludesPatterns(t *testing.T) {
+	router := NewRouter()
+	router.Handle("*/delete", func(event Event) error { return nil })
+	router.Handle(TopicOrdersCreate, func(event Event) error { return nil })
+	router.Handle("orders/*", func(event Event) error { return nil })
+
+	topics := router.Topics()
+	if len(topics) != 3 {
+		t.Fatalf("expected 3 topics, got %v", topics)
+	}
+	if topics[0] != TopicOrdersCreate || topics[1] != "orders/*" || topics[2] != "*/delete" {
+		t.Fatalf("expected exact topic then patterns in precedence order, got %v", topics)
+	}
+}
+
+func TestRouter_PatternTimeout(t *testing.T) {
+	router := NewRouter(WithTopicTimeout("orders/*", 10*time.Millisecond))
+
+	var hasDeadline bool
+	router.HandleContext("orders/*", func(ctx context.Context, event Event) error {
+		_, hasDeadline = ctx.Deadline()
+		return nil
+	})
+
+	if err := router.Dispatch(Event{Metadata: Metadata{Topic: TopicOrdersCreate}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasDeadline {
+		t.Fatal("expected pattern timeout to apply")
+	}
+}
+
+func TestTopic_Match(t *testing.T) {
+	tests := []struct {
+		pattern Topic
+		topic   Topic
+		want    bool
+	}{
+		{"orders/*", TopicOrdersCreate, true},
+		{"orders/*", TopicProductsCreate, false},
+		{"*/delete", TopicCustomersDelete, true},
+		{"*/delete", TopicCustomersUpdate, false},
+		{"*/*", TopicShopRedact, true},
+		{"orders/*", "orders", false},
+		{TopicOrdersCreate, TopicOrdersCreate, true},
+	}
+	for _, tt := range tests {
+		if got := tt.pattern.Match(tt.topic); got != tt.want {
+			t.Fatalf("%q.Match(%q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package shopifywebhook
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Secret is a webhook signing secret (a Shopify app's client secret).
+type Secret struct {
+	// ID identifies the secret. When a request verifies against this
+	// secret, the ID is reported in Metadata.SecretID.
+	ID string
+
+	// Value is the client secret itself.
+	Value string
+
+	// ExpiresAt is when the secret stops being accepted.
+	// The zero value means it never expires.
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the secret has expired at time now.
+func (s Secret) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// SecretProvider supplies the secrets a webhook signature may be verified
+// against. Implement it to load secrets from a vault or configuration
+// service; the context is the incoming request's.
+type SecretProvider interface {
+	// Secrets returns the currently valid secrets. Expired secrets are
+	// ignored by the verifier even if returned.
+	Secrets(ctx context.Context) ([]Secret, error)
+}
+
+// SecretProviderFunc adapts a function to the SecretProvider interface.
+type SecretProviderFunc func(ctx context.Context) ([]Secret, error)
+
+// Secrets calls f(ctx).
+func (f SecretProviderFunc) Secrets(ctx context.Context) ([]Secret, error) {
+	return f(ctx)
+}
+
+// StaticSecret returns a SecretProvider for a single secret that never
+// expires. Its ID is empty.
+func StaticSecret(secret string) SecretProvider {
+	secrets := []Secret{{Value: secret}}
+	return SecretProviderFunc(func(context.Context) ([]Secret, error) {
+		return secrets, nil
+	})
+}
+
+// SecretSet is a SecretProvider holding a current secret and any number of
+// previous ones, for rotating an app's client secret without dropping
+// webhooks signed with the old one.
+//
+//	secrets := shopifywebhook.NewSecretSet(shopifywebhook.Secret{ID: "2025-01", Value: oldSecret})
+//	handler := shopifywebhook.HandlerWithSecrets(secrets, router)
+//
+//	// Later, after rotating in the Partner Dashboard:
+//	secrets.Rotate(shopifywebhook.Secret{ID: "2025-06", Value: newSecret}, 48*time.Hour)
+//
+// It is safe for concurrent use.
+type SecretSet struct {
+	mu      sync.RWMutex
+	secrets []Secret
+}
+
+// NewSecretSet creates a SecretSet. The first secret is the current one.
+func NewSecretSet(secrets ...Secret) *SecretSet {
+	return &SecretSet{secrets: append([]Secret(nil), secrets...)}
+}
+
+// Secrets returns the unexpired secrets, current first.
+func (s *SecretSet) Secrets(context.Context) ([]Secret, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	valid := make([]Secret, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		if !secret.Expired(now) {
+			valid = append(valid, secret)
+		}
+	}
+	return valid, nil
+}
+
+// Rotate makes next the current secret. The previous current secret stays
+// valid for grace (unless it already expires sooner); expired secrets are
+// dropped from the set.
+func (s *SecretSet) Rotate(next Secret, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if len(s.secrets) > 0 {
+		deadline := now.Add(grace)
+		if s.secrets[0].ExpiresAt.IsZero() || deadline.Before(s.secrets[0].ExpiresAt) {
+			s.secrets[0].ExpiresAt = deadline
+		}
+	}
+	rotated := []Secret{next}
+	for _, secret := range s.secrets {
+		if !secret.Expired(now) {
+			rotated = append(rotated, secret)
+		}
+	}
+	s.secrets = rotated
+}
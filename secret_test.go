@@ -0,0 +1,123 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifySignatureWithSecrets_MatchesPrevious(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	secrets := []Secret{
+		{ID: "current", Value: "new-secret"},
+		{ID: "previous", Value: "old-secret", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+
+	matched, err := VerifySignatureWithSecrets(secrets, body, sign("old-secret", body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matched.ID != "previous" {
+		t.Fatalf("expected previous secret to match, got %q", matched.ID)
+	}
+
+	matched, err = VerifySignatureWithSecrets(secrets, body, sign("new-secret", body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matched.ID != "current" {
+		t.Fatalf("expected current secret to match, got %q", matched.ID)
+	}
+}
+
+func TestVerifySignatureWithSecrets_ExpiredRejected(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	secrets := []Secret{
+		{ID: "current", Value: "new-secret"},
+		{ID: "previous", Value: "old-secret", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+
+	_, err := VerifySignatureWithSecrets(secrets, body, sign("old-secret", body))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got: %v", err)
+	}
+}
+
+func TestVerifySignatureWithSecrets_NoSecrets(t *testing.T) {
+	body := []byte(`{}`)
+	_, err := VerifySignatureWithSecrets(nil, body, sign("x", body))
+	if !errors.Is(err, ErrNoSecrets) {
+		t.Fatalf("expected ErrNoSecrets, got: %v", err)
+	}
+}
+
+func TestSecretSet_Rotate(t *testing.T) {
+	set := NewSecretSet(Secret{ID: "v1", Value: "one"})
+	set.Rotate(Secret{ID: "v2", Value: "two"}, time.Hour)
+
+	secrets, _ := set.Secrets(context.Background())
+	if len(secrets) != 2 || secrets[0].ID != "v2" || secrets[1].ID != "v1" {
+		t.Fatalf("unexpected secrets after rotation: %+v", secrets)
+	}
+	if secrets[1].ExpiresAt.IsZero() {
+		t.Fatal("expected previous secret to get an expiry")
+	}
+
+	// Rotating with no grace drops the previous secret immediately.
+	set.Rotate(Secret{ID: "v3", Value: "three"}, 0)
+	secrets, _ = set.Secrets(context.Background())
+	if len(secrets) != 2 || secrets[0].ID != "v3" || secrets[1].ID != "v1" {
+		t.Fatalf("unexpected secrets after second rotation: %+v", secrets)
+	}
+}
+
+func TestHandlerWithSecrets_ReportsSecretID(t *testing.T) {
+	set := NewSecretSet(
+		Secret{ID: "v2", Value: "new-secret"},
+		Secret{ID: "v1", Value: "old-secret", ExpiresAt: time.Now().Add(time.Hour)},
+	)
+
+	var secretID string
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		secretID = event.Metadata.SecretID
+		return nil
+	})
+
+	handler := HandlerWithSecrets(set, router)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("old-secret", `{"id":1}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if secretID != "v1" {
+		t.Fatalf("expected SecretID %q, got %q", "v1", secretID)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, signedRequest("unknown-secret", `{"id":1}`, TopicOrdersCreate))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown secret, got %d", rr.Code)
+	}
+}
+
+func TestMiddlewareWithSecrets_ProviderError(t *testing.T) {
+	failing := SecretProviderFunc(func(context.Context) ([]Secret, error) {
+		return nil, errors.New("vault unavailable")
+	})
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("inner handler should not be called")
+	})
+
+	rr := httptest.NewRecorder()
+	MiddlewareWithSecrets(failing)(inner).ServeHTTP(rr, signedRequest("s", `{}`, TopicOrdersCreate))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
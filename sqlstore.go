@@ -0,0 +1,333 @@
+package shopifywebhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect selects the SQL syntax an SQLStore generates.
+type SQLDialect int
+
+const (
+	// DialectPostgres targets PostgreSQL 9.5 or later ($1 placeholders,
+	// INSERT ... ON CONFLICT).
+	DialectPostgres SQLDialect = iota
+
+	// DialectMySQL targets MySQL 5.7+ and MariaDB (INSERT ... ON DUPLICATE
+	// KEY UPDATE). The connection must report changed rather than matched
+	// rows, which is the default for github.com/go-sql-driver/mysql
+	// (clientFoundRows=false).
+	DialectMySQL
+
+	// DialectSQLite targets SQLite 3.24 or later (INSERT ... ON CONFLICT).
+	DialectSQLite
+)
+
+// String returns the dialect name.
+func (d SQLDialect) String() string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	case DialectSQLite:
+		return "sqlite"
+	default:
+		return fmt.Sprintf("SQLDialect(%d)", int(d))
+	}
+}
+
+// DefaultSQLTable is the table an SQLStore uses unless configured
+// otherwise with WithSQLTable.
+const DefaultSQLTable = "shopify_webhook_events"
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLStore is an IdempotencyStore and ClaimStore backed by a database/sql
+// database, for deployments with several instances behind one endpoint.
+// Claims are made with a single conditional upsert, so they are atomic
+// across processes.
+//
+// Rows are keyed by event ID and hold the claim state and its expiry as
+// Unix nanoseconds, so the schema is the same in every dialect:
+//
+//	CREATE TABLE shopify_webhook_events (
+//	    event_id   VARCHAR(255) NOT NULL PRIMARY KEY,
+//	    completed  SMALLINT     NOT NULL,
+//	    expires_at BIGINT       NOT NULL
+//	)
+//
+// NewSQLStore creates the table if it does not exist. A background
+// goroutine deletes expired rows periodically; call Close to stop it.
+//
+// The store does not import a driver; register one (such as
+// github.com/jackc/pgx/v5/stdlib, github.com/go-sql-driver/mysql or
+// modernc.org/sqlite) and pass the opened *sql.DB.
+type SQLStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	ttl     time.Duration
+	queries sqlQueries
+	done    chan struct{}
+}
+
+type sqlQueries struct {
+	exists    string
+	completed string
+	claim     string
+	complete  string
+	release   string
+	cleanup   string
+	schema    []string
+}
+
+// SQLStoreOption configures an SQLStore.
+type SQLStoreOption func(*sqlStoreConfig)
+
+type sqlStoreConfig struct {
+	table           string
+	cleanupInterval time.Duration
+	skipSchema      bool
+}
+
+// WithSQLTable sets the table name, optionally schema-qualified
+// ("webhooks.events"). Default: DefaultSQLTable.
+func WithSQLTable(name string) SQLStoreOption {
+	return func(c *sqlStoreConfig) {
+		c.table = name
+	}
+}
+
+// WithSQLCleanupInterval sets how often expired rows are deleted.
+// Default: half the TTL. A negative interval disables background cleanup;
+// call Cleanup yourself instead.
+func WithSQLCleanupInterval(d time.Duration) SQLStoreOption {
+	return func(c *sqlStoreConfig) {
+		c.cleanupInterval = d
+	}
+}
+
+// WithoutSQLSchema skips creating the table, for databases whose schema is
+// managed by migrations.
+func WithoutSQLSchema() SQLStoreOption {
+	return func(c *sqlStoreConfig) {
+		c.skipSchema = true
+	}
+}
+
+// NewSQLStore creates an SQLStore on db using dialect. Completed events
+// are remembered for ttl; typical TTL: 24 hours, as for NewMemoryStore.
+func NewSQLStore(ctx context.Context, db *sql.DB, dialect SQLDialect, ttl time.Duration, opts ...SQLStoreOption) (*SQLStore, error) {
+	cfg := &sqlStoreConfig{
+		table:           DefaultSQLTable,
+		cleanupInterval: ttl / 2,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if !sqlIdentifier.MatchString(cfg.table) {
+		return nil, fmt.Errorf("shopifywebhook: invalid SQL table name %q", cfg.table)
+	}
+
+	queries, err := buildSQLQueries(dialect, cfg.table)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLStore{
+		db:      db,
+		dialect: dialect,
+		ttl:     ttl,
+		queries: queries,
+		done:    make(chan struct{}),
+	}
+	if !cfg.skipSchema {
+		for _, stmt := range queries.schema {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("shopifywebhook: create SQL schema: %w", err)
+			}
+		}
+	}
+	if cfg.cleanupInterval > 0 {
+		go s.cleanup(cfg.cleanupInterval)
+	}
+	return s, nil
+}
+
+func buildSQLQueries(dialect SQLDialect, table string) (sqlQueries, error) {
+	var q sqlQueries
+	switch dialect {
+	case DialectPostgres, DialectSQLite:
+		q.claim = `INSERT INTO ` + table + ` (event_id, completed, expires_at) VALUES (?, 0, ?)
+ON CONFLICT (event_id) DO UPDATE SET completed = 0, expires_at = excluded.expires_at
+WHERE ` + table + `.expires_at <= ?`
+		q.complete = `INSERT INTO ` + table + ` (event_id, completed, expires_at) VALUES (?, 1, ?)
+ON CONFLICT (event_id) DO UPDATE SET completed = 1, expires_at = excluded.expires_at`
+		q.schema = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+    event_id   VARCHAR(255) NOT NULL PRIMARY KEY,
+    completed  SMALLINT     NOT NULL,
+    expires_at BIGINT       NOT NULL
+)`,
+			`CREATE INDEX IF NOT EXISTS ` + indexName(table) + ` ON ` + table + ` (expires_at)`,
+		}
+	case DialectMySQL:
+		// Assignments run left to right, so the completed condition still
+		// sees the old expires_at.
+		q.claim = `INSERT INTO ` + table + ` (event_id, completed, expires_at) VALUES (?, 0, ?)
+ON DUPLICATE KEY UPDATE
+    completed = IF(expires_at <= ?, 0, completed),
+    expires_at = IF(expires_at <= ?, VALUES(expires_at), expires_at)`
+		q.complete = `INSERT INTO ` + table + ` (event_id, completed, expires_at) VALUES (?, 1, ?)
+ON DUPLICATE KEY UPDATE completed = 1, expires_at = VALUES(expires_at)`
+		q.schema = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (
+    event_id   VARCHAR(255) NOT NULL PRIMARY KEY,
+    completed  SMALLINT     NOT NULL,
+    expires_at BIGINT       NOT NULL,
+    INDEX ` + indexName(table) + ` (expires_at)
+)`,
+		}
+	default:
+		return sqlQueries{}, fmt.Errorf("shopifywebhook: unknown SQL dialect %v", dialect)
+	}
+	q.exists = `SELECT 1 FROM ` + table + ` WHERE event_id = ? AND completed = 1 AND expires_at > ?`
+	q.completed = `SELECT completed FROM ` + table + ` WHERE event_id = ?`
+	q.release = `DELETE FROM ` + table + ` WHERE event_id = ? AND completed = 0`
+	q.cleanup = `DELETE FROM ` + table + ` WHERE expires_at <= ?`
+
+	if dialect == DialectPostgres {
+		q.claim = numberPlaceholders(q.claim)
+		q.complete = numberPlaceholders(q.complete)
+		q.exists = numberPlaceholders(q.exists)
+		q.completed = numberPlaceholders(q.completed)
+		q.release = numberPlaceholders(q.release)
+		q.cleanup = numberPlaceholders(q.cleanup)
+	}
+	return q, nil
+}
+
+func indexName(table string) string {
+	return strings.ReplaceAll(table, ".", "_") + "_expires_at_idx"
+}
+
+// numberPlaceholders rewrites "?" placeholders as "$1", "$2", ...
+func numberPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Exists checks if the event ID has been completed within the TTL window.
+// Events that are only claimed are not reported as existing.
+func (s *SQLStore) Exists(ctx context.Context, eventID string) (bool, error) {
+	var one int
+	err := s.db.QueryRowContext(ctx, s.queries.exists, eventID, time.Now().UnixNano()).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: SQL store exists: %w", err)
+	}
+	return true, nil
+}
+
+// Store records an event ID as completed for the store's TTL.
+func (s *SQLStore) Store(ctx context.Context, eventID string) error {
+	expires := time.Now().Add(s.ttl).UnixNano()
+	if _, err := s.db.ExecContext(ctx, s.queries.complete, eventID, expires); err != nil {
+		return fmt.Errorf("shopifywebhook: SQL store complete: %w", err)
+	}
+	return nil
+}
+
+// Claim atomically reserves the event ID for lease. It fails if the event
+// was completed within the TTL, or with ErrAlreadyClaimed if it is claimed
+// under an unexpired lease.
+func (s *SQLStore) Claim(ctx context.Context, eventID string, lease time.Duration) (bool, error) {
+	now := time.Now()
+	args := []any{eventID, now.Add(lease).UnixNano(), now.UnixNano()}
+	if s.dialect == DialectMySQL {
+		args = append(args, now.UnixNano()) // once per IF
+	}
+	res, err := s.db.ExecContext(ctx, s.queries.claim, args...)
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: SQL store claim: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("shopifywebhook: SQL store claim: %w", err)
+	}
+	if n > 0 {
+		return true, nil
+	}
+
+	// The row was live: tell a completed event from a claimed one. If it
+	// expired in the meantime, report it as claimed, so the delivery is
+	// retried rather than acknowledged.
+	var completed int
+	err = s.db.QueryRowContext(ctx, s.queries.completed, eventID).Scan(&completed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("shopifywebhook: SQL store claim: %w", err)
+	}
+	if completed == 1 {
+		return false, nil
+	}
+	return false, ErrAlreadyClaimed
+}
+
+// Complete marks the event ID as processed for the store's TTL.
+func (s *SQLStore) Complete(ctx context.Context, eventID string) error {
+	return s.Store(ctx, eventID)
+}
+
+// Release drops an uncompleted claim on the event ID.
+func (s *SQLStore) Release(ctx context.Context, eventID string) error {
+	if _, err := s.db.ExecContext(ctx, s.queries.release, eventID); err != nil {
+		return fmt.Errorf("shopifywebhook: SQL store release: %w", err)
+	}
+	return nil
+}
+
+// Cleanup deletes expired rows and returns how many were removed.
+func (s *SQLStore) Cleanup(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.queries.cleanup, time.Now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("shopifywebhook: SQL store cleanup: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Close stops the background cleanup goroutine. It does not close the
+// database.
+func (s *SQLStore) Close() {
+	close(s.done)
+}
+
+func (s *SQLStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Errors are retried on the next tick.
+			_, _ = s.Cleanup(context.Background())
+		case <-s.done:
+			return
+		}
+	}
+}
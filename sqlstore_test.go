@@ -0,0 +1,273 @@
+package shopifywebhook
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSQLDriver is a minimal in-memory database/sql driver that understands
+// the statements SQLStore issues, in any dialect. It lets the store's logic
+// be tested without a real database or a third-party driver.
+type fakeSQLDriver struct {
+	mu   sync.Mutex
+	rows map[string]fakeSQLRow
+}
+
+type fakeSQLRow struct {
+	completed bool
+	expires   int64
+}
+
+var fakeSQLSeq atomic.Int64
+
+// openFakeSQL registers a fresh fake database and opens it.
+func openFakeSQL(t *testing.T) (*sql.DB, *fakeSQLDriver) {
+	t.Helper()
+	d := &fakeSQLDriver{rows: make(map[string]fakeSQLRow)}
+	name := fmt.Sprintf("shopifywebhook-fake-%d", fakeSQLSeq.Add(1))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func (d *fakeSQLDriver) Open(string) (driver.Conn, error) { return fakeSQLConn{d}, nil }
+
+type fakeSQLConn struct{ d *fakeSQLDriver }
+
+func (c fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeSQLStmt{d: c.d, query: query}, nil
+}
+func (c fakeSQLConn) Close() error { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("fake: transactions not supported")
+}
+
+type fakeSQLStmt struct {
+	d     *fakeSQLDriver
+	query string
+}
+
+func (s fakeSQLStmt) Close() error  { return nil }
+func (s fakeSQLStmt) NumInput() int { return -1 }
+
+func (s fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "CREATE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(q, "INSERT") && strings.Contains(q, ", 0, "):
+		id, expires, now := args[0].(string), args[1].(int64), args[2].(int64)
+		if row, ok := s.d.rows[id]; ok && row.expires > now {
+			return driver.RowsAffected(0), nil
+		}
+		s.d.rows[id] = fakeSQLRow{expires: expires}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "INSERT") && strings.Contains(q, ", 1, "):
+		s.d.rows[args[0].(string)] = fakeSQLRow{completed: true, expires: args[1].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(q, "DELETE") && strings.Contains(q, "completed = 0"):
+		id := args[0].(string)
+		if row, ok := s.d.rows[id]; ok && !row.completed {
+			delete(s.d.rows, id)
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(q, "DELETE"):
+		var n int64
+		for id, row := range s.d.rows {
+			if row.expires <= args[0].(int64) {
+				delete(s.d.rows, id)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("fake: unsupported statement %q", q)
+}
+
+func (s fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	row, ok := s.d.rows[args[0].(string)]
+	if strings.HasPrefix(s.query, "SELECT completed") {
+		completed := int64(0)
+		if row.completed {
+			completed = 1
+		}
+		return &fakeSQLRows{more: ok, value: completed}, nil
+	}
+	found := ok && row.completed && row.expires > args[1].(int64)
+	return &fakeSQLRows{more: found, value: 1}, nil
+}
+
+type fakeSQLRows struct {
+	more  bool
+	value int64
+}
+
+func (r *fakeSQLRows) Columns() []string { return []string{"1"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if !r.more {
+		return io.EOF
+	}
+	r.more = false
+	dest[0] = r.value
+	return nil
+}
+
+func TestSQLStore_ClaimLifecycle(t *testing.T) {
+	for _, dialect := range []SQLDialect{DialectPostgres, DialectMySQL, DialectSQLite} {
+		t.Run(fmt.Sprint(dialect), func(t *testing.T) {
+			db, _ := openFakeSQL(t)
+			ctx := context.Background()
+			store, err := NewSQLStore(ctx, db, dialect, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			ok, err := store.Claim(ctx, "evt", time.Minute)
+			if err != nil || !ok {
+				t.Fatalf("expected first claim to succeed, got %v, %v", ok, err)
+			}
+			if ok, err := store.Claim(ctx, "evt", time.Minute); ok || !errors.Is(err, ErrAlreadyClaimed) {
+				t.Fatalf("expected concurrent claim to fail with ErrAlreadyClaimed, got %v, %v", ok, err)
+			}
+			if exists, _ := store.Exists(ctx, "evt"); exists {
+				t.Fatal("expected a claimed event not to exist yet")
+			}
+
+			_ = store.Release(ctx, "evt")
+			if ok, _ := store.Claim(ctx, "evt", time.Minute); !ok {
+				t.Fatal("expected claim to succeed after release")
+			}
+
+			_ = store.Complete(ctx, "evt")
+			if exists, _ := store.Exists(ctx, "evt"); !exists {
+				t.Fatal("expected completed event to exist")
+			}
+			if ok, err := store.Claim(ctx, "evt", time.Minute); ok || err != nil {
+				t.Fatalf("expected claim on a completed event to fail without error, got %v, %v", ok, err)
+			}
+			_ = store.Release(ctx, "evt")
+			if exists, _ := store.Exists(ctx, "evt"); !exists {
+				t.Fatal("expected release not to drop a completed event")
+			}
+		})
+	}
+}
+
+func TestSQLStore_ExpiryAndCleanup(t *testing.T) {
+	db, fake := openFakeSQL(t)
+	ctx := context.Background()
+	store, err := NewSQLStore(ctx, db, DialectSQLite, 20*time.Millisecond, WithSQLCleanupInterval(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	_ = store.Store(ctx, "done")
+	_, _ = store.Claim(ctx, "claimed", 20*time.Millisecond)
+	_ = store.Store(ctx, "other")
+
+	time.Sleep(40 * time.Millisecond)
+
+	if exists, _ := store.Exists(ctx, "done"); exists {
+		t.Fatal("expected completed event to expire")
+	}
+	if ok, _ := store.Claim(ctx, "claimed", time.Minute); !ok {
+		t.Fatal("expected expired claim to be claimable")
+	}
+
+	n, err := store.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 expired rows removed, got %d", n)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.rows["claimed"]; !ok {
+		t.Fatal("expected live claim to survive cleanup")
+	}
+}
+
+func TestSQLStore_WithHandler(t *testing.T) {
+	db, _ := openFakeSQL(t)
+	store, err := NewSQLStore(context.Background(), db, DialectPostgres, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var calls atomic.Int32
+	router := NewRouter()
+	router.Handle(TopicOrdersCreate, func(event Event) error {
+		calls.Add(1)
+		return nil
+	})
+	handler := Handler("test-secret", router, WithIdempotencyStore(store))
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), signedRequest("test-secret", `{"id":1}`, TopicOrdersCreate))
+		}()
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 dispatch, got %d", got)
+	}
+}
+
+func TestSQLStore_Queries(t *testing.T) {
+	q, err := buildSQLQueries(DialectPostgres, "webhooks.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q.claim, "VALUES ($1, 0, $2)") || !strings.Contains(q.claim, "<= $3") {
+		t.Fatalf("expected numbered placeholders, got %s", q.claim)
+	}
+	if strings.Contains(q.claim, "?") {
+		t.Fatalf("unexpected ? placeholder in %s", q.claim)
+	}
+
+	q, _ = buildSQLQueries(DialectMySQL, DefaultSQLTable)
+	if !strings.Contains(q.claim, "ON DUPLICATE KEY UPDATE") {
+		t.Fatalf("expected MySQL upsert, got %s", q.claim)
+	}
+
+	if _, err := buildSQLQueries(SQLDialect(99), DefaultSQLTable); err == nil {
+		t.Fatal("expected error for unknown dialect")
+	}
+}
+
+func TestNewSQLStore_InvalidTable(t *testing.T) {
+	db, _ := openFakeSQL(t)
+	_, err := NewSQLStore(context.Background(), db, DialectSQLite, time.Hour, WithSQLTable("events; DROP TABLE users"))
+	if err == nil {
+		t.Fatal("expected error for invalid table name")
+	}
+}
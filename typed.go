@@ -0,0 +1,114 @@
+package shopifywebhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TypedHandlerFunc is a handler that receives the webhook payload already
+// decoded into T.
+type TypedHandlerFunc[T any] func(ctx context.Context, event Event, payload T) error
+
+// HandleTyped registers a handler that decodes the event body into T before
+// calling fn. Decode failures are returned as *DecodeError, so they reach
+// the Router's error handler without fn being called.
+//
+//	shopifywebhook.HandleTyped(router, shopifywebhook.TopicOrdersCreate,
+//	    func(ctx context.Context, event shopifywebhook.Event, order shopifywebhook.Order) error {
+//	        log.Printf("New order #%d", order.OrderNumber)
+//	        return nil
+//	    })
+//
+// r may be a Router or a Group. Panics if a handler is already registered
+// for the topic.
+func HandleTyped[T any](r Registrar, topic Topic, fn TypedHandlerFunc[T]) {
+	r.HandleContext(topic, typedHandler(fn))
+}
+
+func typedHandler[T any](fn TypedHandlerFunc[T]) ContextHandlerFunc {
+	return func(ctx context.Context, event Event) error {
+		var payload T
+		if err := event.Unmarshal(&payload); err != nil {
+			return &DecodeError{
+				Topic: event.Metadata.Topic,
+				Type:  fmt.Sprintf("%T", payload),
+				Err:   err,
+			}
+		}
+		return fn(ctx, event, payload)
+	}
+}
+
+// payloadTypes maps each known topic to a constructor for its payload type.
+var payloadTypes = map[Topic]func() any{
+	TopicOrdersCreate:             func() any { return new(Order) },
+	TopicOrdersUpdate:             func() any { return new(Order) },
+	TopicOrdersDelete:             func() any { return new(Order) },
+	TopicOrdersCancelled:          func() any { return new(Order) },
+	TopicOrdersFulfilled:          func() any { return new(Order) },
+	TopicOrdersPaid:               func() any { return new(Order) },
+	TopicOrdersPartiallyFulfilled: func() any { return new(Order) },
+
+	TopicProductsCreate: func() any { return new(Product) },
+	TopicProductsUpdate: func() any { return new(Product) },
+	TopicProductsDelete: func() any { return new(Product) },
+
+	TopicCustomersCreate:  func() any { return new(Customer) },
+	TopicCustomersUpdate:  func() any { return new(Customer) },
+	TopicCustomersDelete:  func() any { return new(Customer) },
+	TopicCustomersEnable:  func() any { return new(Customer) },
+	TopicCustomersDisable: func() any { return new(Customer) },
+
+	TopicCollectionsCreate: func() any { return new(Collection) },
+	TopicCollectionsUpdate: func() any { return new(Collection) },
+	TopicCollectionsDelete: func() any { return new(Collection) },
+
+	TopicCartsCreate: func() any { return new(Cart) },
+	TopicCartsUpdate: func() any { return new(Cart) },
+
+	TopicCheckoutsCreate: func() any { return new(Checkout) },
+	TopicCheckoutsUpdate: func() any { return new(Checkout) },
+	TopicCheckoutsDelete: func() any { return new(Checkout) },
+
+	TopicRefundsCreate: func() any { return new(Refund) },
+
+	TopicCustomersDataRequest: func() any { return new(CustomerDataRequest) },
+	TopicCustomersRedact:      func() any { return new(CustomerRedact) },
+	TopicShopRedact:           func() any { return new(ShopRedact) },
+}
+
+// NewPayload returns a pointer to a zero value of the built-in payload type
+// for the topic (e.g., *Order for orders/create), or false if the topic has
+// no built-in type.
+func NewPayload(topic Topic) (any, bool) {
+	newFn, ok := payloadTypes[topic]
+	if !ok {
+		return nil, false
+	}
+	return newFn(), true
+}
+
+// Payload decodes the raw body into the built-in payload type for the
+// event's topic and returns a pointer to it.
+//
+//	p, err := event.Payload()
+//	if err != nil { ... }
+//	order := p.(*shopifywebhook.Order)
+//
+// Returns ErrNoPayloadType for topics without a built-in type, and a
+// *DecodeError if the body cannot be decoded.
+func (e *Event) Payload() (any, error) {
+	payload, ok := NewPayload(e.Metadata.Topic)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoPayloadType, e.Metadata.Topic)
+	}
+	if err := e.Unmarshal(payload); err != nil {
+		return nil, &DecodeError{
+			Topic: e.Metadata.Topic,
+			Type:  strings.TrimPrefix(fmt.Sprintf("%T", payload), "*"),
+			Err:   err,
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,115 @@
+package shopifywebhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHandleTyped_DecodesPayload(t *testing.T) {
+	router := NewRouter()
+
+	var got Order
+	HandleTyped(router, TopicOrdersCreate, func(ctx context.Context, event Event, order Order) error {
+		got = order
+		return nil
+	})
+
+	err := router.Dispatch(Event{
+		Metadata: Metadata{Topic: TopicOrdersCreate},
+		RawBody:  []byte(`{"id":42,"order_number":1001}`),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != 42 || got.OrderNumber != 1001 {
+		t.Fatalf("unexpected order: %+v", got)
+	}
+}
+
+func TestHandleTyped_DecodeErrorReachesErrorHandler(t *testing.T) {
+	var captured error
+	router := NewRouter(WithErrorHandler(func(event Event, err error) {
+		captured = err
+	}))
+
+	called := false
+	HandleTyped(router, TopicRefundsCreate, func(ctx context.Context, event Event, refund Refund) error {
+		called = true
+		return nil
+	})
+
+	err := router.Dispatch(Event{
+		Metadata: Metadata{Topic: TopicRefundsCreate},
+		RawBody:  []byte(`{"id":"not-a-number"}`),
+	})
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected *DecodeError, got: %v", err)
+	}
+	if decodeErr.Topic != TopicRefundsCreate || decodeErr.Type != "shopifywebhook.Refund" {
+		t.Fatalf("unexpected decode error fields: %+v", decodeErr)
+	}
+	if !errors.As(captured, &decodeErr) {
+		t.Fatalf("expected error handler to receive *DecodeError, got: %v", captured)
+	}
+	if called {
+		t.Fatal("handler should not be called on decode failure")
+	}
+}
+
+func TestEvent_Payload(t *testing.T) {
+	event := Event{
+		Metadata: Metadata{Topic: TopicProductsUpdate},
+		RawBody:  []byte(`{"id":7,"title":"Shirt"}`),
+	}
+
+	p, err := event.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	product, ok := p.(*Product)
+	if !ok {
+		t.Fatalf("expected *Product, got %T", p)
+	}
+	if product.ID != 7 || product.Title != "Shirt" {
+		t.Fatalf("unexpected product: %+v", product)
+	}
+}
+
+func TestEvent_Payload_UnknownTopic(t *testing.T) {
+	event := Event{Metadata: Metadata{Topic: "some/unknown_topic"}, RawBody: []byte(`{}`)}
+
+	if _, err := event.Payload(); !errors.Is(err, ErrNoPayloadType) {
+		t.Fatalf("expected ErrNoPayloadType, got: %v", err)
+	}
+}
+
+func TestNewPayload_KnownTopics(t *testing.T) {
+	tests := []struct {
+		topic Topic
+		want  any
+	}{
+		{TopicOrdersPaid, &Order{}},
+		{TopicCustomersUpdate, &Customer{}},
+		{TopicCollectionsCreate, &Collection{}},
+		{TopicRefundsCreate, &Refund{}},
+		{TopicShopRedact, &ShopRedact{}},
+	}
+
+	for _, tt := range tests {
+		got, ok := NewPayload(tt.topic)
+		if !ok {
+			t.Fatalf("expected payload type for %s", tt.topic)
+		}
+		if gotType, wantType := typeString(got), typeString(tt.want); gotType != wantType {
+			t.Fatalf("%s: expected %s, got %s", tt.topic, wantType, gotType)
+		}
+	}
+}
+
+func typeString(v any) string {
+	return fmt.Sprintf("%T", v)
+}
@@ -1,14 +1,33 @@
 package shopifywebhook
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// DefaultMaxBodySize is the largest request body VerifyRequest reads unless
+// configured otherwise with WithMaxBodySize. Shopify payloads are far
+// smaller; the limit stops a client from making the server buffer an
+// arbitrarily large body before the signature is checked.
+const DefaultMaxBodySize int64 = 10 << 20 // 10 MiB
+
+// maxPooledBuffer caps the size of buffers returned to bodyPool so a rare
+// large payload doesn't pin memory.
+const maxPooledBuffer = 1 << 20
+
+var bodyPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
 // VerifySignature performs constant-time HMAC-SHA256 verification.
 //
 // Parameters:
@@ -28,6 +47,22 @@ func VerifySignature(secret string, body []byte, signature string) error {
 	return nil
 }
 
+// VerifySignatureWithSecrets verifies the signature against each unexpired
+// secret and returns the one that matched.
+//
+// Every secret is checked, with constant-time comparisons, regardless of
+// whether an earlier one matched, so timing does not reveal which secret
+// (if any) was used. Returns ErrNoSecrets if none of the secrets are
+// valid, and ErrInvalidSignature if no secret matches.
+func VerifySignatureWithSecrets(secrets []Secret, body []byte, signature string) (Secret, error) {
+	valid := unexpired(secrets)
+	macs := newMACs(valid)
+	for _, mac := range macs {
+		mac.Write(body)
+	}
+	return matchSecret(valid, macs, signature)
+}
+
 // VerifyRequest reads the request body, verifies the HMAC-SHA256 signature,
 // and returns the raw body bytes.
 //
@@ -35,22 +70,147 @@ func VerifySignature(secret string, body []byte, signature string) error {
 // problem: it reads the raw bytes first, verifies against those bytes, then
 // returns them for JSON decoding.
 //
+// The HMAC is computed while the body streams into a pooled buffer. Bodies
+// larger than DefaultMaxBodySize (or the WithMaxBodySize limit) are
+// rejected with ErrBodyTooLarge.
+//
 // The request body is consumed. The returned bytes can be passed to
 // json.Unmarshal or used with Event.Unmarshal.
-func VerifyRequest(secret string, r *http.Request) ([]byte, error) {
+func VerifyRequest(secret string, r *http.Request, opts ...VerifyOption) ([]byte, error) {
+	body, _, err := VerifyRequestWithSecrets(StaticSecret(secret), r, opts...)
+	return body, err
+}
+
+// VerifyRequestWithSecrets is like VerifyRequest, but verifies against the
+// secrets supplied by p and also returns the secret that matched.
+func VerifyRequestWithSecrets(p SecretProvider, r *http.Request, opts ...VerifyOption) ([]byte, Secret, error) {
+	cfg := verifyConfig{maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	signature := r.Header.Get("X-Shopify-Hmac-Sha256")
 	if signature == "" {
-		return nil, ErrMissingSignature
+		return nil, Secret{}, ErrMissingSignature
+	}
+	if cfg.requireHeaders && r.Header.Get("X-Shopify-Topic") == "" {
+		return nil, Secret{}, ErrMissingTopic
+	}
+	if cfg.maxBodySize > 0 && r.ContentLength > cfg.maxBodySize {
+		return nil, Secret{}, bodyTooLarge(cfg.maxBodySize)
 	}
 
-	body, err := io.ReadAll(r.Body)
+	secrets, err := p.Secrets(r.Context())
 	if err != nil {
-		return nil, fmt.Errorf("shopifywebhook: reading request body: %w", err)
+		return nil, Secret{}, fmt.Errorf("shopifywebhook: loading secrets: %w", err)
+	}
+	valid := unexpired(secrets)
+	macs := newMACs(valid)
+
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bodyPool.Put(buf)
+		}
+	}()
+
+	writers := make([]io.Writer, 0, len(macs)+1)
+	writers = append(writers, buf)
+	for _, mac := range macs {
+		writers = append(writers, mac)
+	}
+
+	var src io.Reader = r.Body
+	if cfg.maxBodySize > 0 {
+		src = io.LimitReader(r.Body, cfg.maxBodySize+1)
 	}
 	defer r.Body.Close()
 
-	if err := VerifySignature(secret, body, signature); err != nil {
-		return nil, err
+	n, err := io.Copy(io.MultiWriter(writers...), src)
+	if err != nil {
+		return nil, Secret{}, fmt.Errorf("shopifywebhook: reading request body: %w", err)
+	}
+	if cfg.maxBodySize > 0 && n > cfg.maxBodySize {
+		return nil, Secret{}, bodyTooLarge(cfg.maxBodySize)
+	}
+
+	secret, err := matchSecret(valid, macs, signature)
+	if err != nil {
+		return nil, Secret{}, err
+	}
+	return bytes.Clone(buf.Bytes()), secret, nil
+}
+
+// VerifyOption configures VerifyRequest and VerifyRequestWithSecrets.
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	maxBodySize    int64
+	requireHeaders bool
+}
+
+// WithMaxBodySize sets the largest request body accepted, in bytes.
+// Larger bodies are rejected with ErrBodyTooLarge — without reading them
+// at all if the request declares its Content-Length. A value <= 0 removes
+// the limit. Default: DefaultMaxBodySize.
+func WithMaxBodySize(n int64) VerifyOption {
+	return func(c *verifyConfig) {
+		c.maxBodySize = n
+	}
+}
+
+// WithEarlyHeaderCheck rejects requests missing the X-Shopify-Topic header
+// with ErrMissingTopic before the body is read. (A missing signature header
+// is always rejected before reading.)
+func WithEarlyHeaderCheck() VerifyOption {
+	return func(c *verifyConfig) {
+		c.requireHeaders = true
+	}
+}
+
+func bodyTooLarge(limit int64) error {
+	return fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, limit)
+}
+
+func unexpired(secrets []Secret) []Secret {
+	now := time.Now()
+	valid := make([]Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		if !secret.Expired(now) {
+			valid = append(valid, secret)
+		}
+	}
+	return valid
+}
+
+func newMACs(secrets []Secret) []hash.Hash {
+	macs := make([]hash.Hash, len(secrets))
+	for i, secret := range secrets {
+		macs[i] = hmac.New(sha256.New, []byte(secret.Value))
+	}
+	return macs
+}
+
+// matchSecret compares the signature against every MAC in constant time
+// and returns the first secret that matched.
+func matchSecret(secrets []Secret, macs []hash.Hash, signature string) (Secret, error) {
+	if len(secrets) == 0 {
+		return Secret{}, ErrNoSecrets
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return Secret{}, ErrInvalidSignature
+	}
+
+	matched, found := 0, 0
+	for i, mac := range macs {
+		eq := subtle.ConstantTimeCompare(mac.Sum(nil), sig)
+		matched = subtle.ConstantTimeSelect(eq&^found, i, matched)
+		found |= eq
+	}
+	if found == 0 {
+		return Secret{}, ErrInvalidSignature
 	}
-	return body, nil
+	return secrets[matched], nil
 }
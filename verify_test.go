@@ -125,3 +125,91 @@ func TestVerifyRequest_BodyConsumed(t *testing.T) {
 		t.Fatalf("expected body to be consumed, got %d bytes", len(remaining))
 	}
 }
+
+func TestVerifyRequest_BodyTooLarge_ContentLength(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	req := httptest.NewRequest("POST", "/webhooks", &failingReader{})
+	req.ContentLength = int64(len(body))
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(body)))
+
+	// failingReader fails the test if read: the declared length alone
+	// must be enough to reject the request.
+	_, err := VerifyRequest("secret", req, WithMaxBodySize(10))
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected ErrBodyTooLarge, got: %v", err)
+	}
+}
+
+func TestVerifyRequest_BodyTooLarge_Streamed(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	req := httptest.NewRequest("POST", "/webhooks", io.MultiReader(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(body)))
+
+	_, err := VerifyRequest("secret", req, WithMaxBodySize(10))
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected ErrBodyTooLarge, got: %v", err)
+	}
+}
+
+func TestVerifyRequest_AtLimit(t *testing.T) {
+	body := strings.Repeat("x", 10)
+	req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(body))
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(body)))
+
+	got, err := VerifyRequest("secret", req, WithMaxBodySize(10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != body {
+		t.Fatalf("expected body %q, got %q", body, got)
+	}
+}
+
+func TestVerifyRequest_NoLimit(t *testing.T) {
+	body := strings.Repeat("x", int(DefaultMaxBodySize)+1)
+	req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(body))
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(body)))
+
+	if _, err := VerifyRequest("secret", req, WithMaxBodySize(0)); err != nil {
+		t.Fatalf("unexpected error with limit disabled: %v", err)
+	}
+}
+
+func TestVerifyRequest_EarlyHeaderCheck(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhooks", &failingReader{})
+	req.Header.Set("X-Shopify-Hmac-Sha256", "c2ln")
+
+	_, err := VerifyRequest("secret", req, WithEarlyHeaderCheck())
+	if !errors.Is(err, ErrMissingTopic) {
+		t.Fatalf("expected ErrMissingTopic, got: %v", err)
+	}
+}
+
+func TestVerifyRequest_ReturnedBodyNotShared(t *testing.T) {
+	first := `{"first":true}`
+	req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(first))
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(first)))
+	got, err := VerifyRequest("secret", req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A second request reuses the pooled buffer; the first body must not change.
+	second := `{"second":true,"padding":"xxxxxxxx"}`
+	req = httptest.NewRequest("POST", "/webhooks", strings.NewReader(second))
+	req.Header.Set("X-Shopify-Hmac-Sha256", sign("secret", []byte(second)))
+	if _, err := VerifyRequest("secret", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(got) != first {
+		t.Fatalf("expected first body to be unchanged, got %q", got)
+	}
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	panic("body should not be read")
+}
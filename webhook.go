@@ -1,6 +1,7 @@
 package shopifywebhook
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -83,6 +84,15 @@ type Metadata struct {
 	EventID     string
 	TriggeredAt time.Time
 	APIVersion  string
+
+	// SecretID is the ID of the Secret the signature was verified against.
+	// Set by Middleware and Handler; empty for StaticSecret.
+	SecretID string
+
+	// Stale is set when a ReplayWindow in FlagOnly mode finds the event
+	// outside the window (too old, too far in the future, or missing a
+	// valid triggered-at timestamp).
+	Stale bool
 }
 
 // Event represents a parsed and verified Shopify webhook event.
@@ -102,11 +112,29 @@ func (e *Event) Unmarshal(v any) error {
 // HandlerFunc is the function signature for webhook topic handlers.
 type HandlerFunc func(event Event) error
 
+// ContextHandlerFunc is a webhook topic handler that receives a context.
+//
+// In sync mode the context is the HTTP request context. In async mode it is
+// detached from the request but cancelled when the WorkerPool shuts down.
+// Per-topic timeouts set with WithTopicTimeout are applied to it.
+type ContextHandlerFunc func(ctx context.Context, event Event) error
+
+// AdaptHandler converts a HandlerFunc into a ContextHandlerFunc that
+// ignores the context.
+func AdaptHandler(h HandlerFunc) ContextHandlerFunc {
+	return func(_ context.Context, event Event) error {
+		return h(event)
+	}
+}
+
 // ErrorHandlerFunc is called when a HandlerFunc returns an error.
 type ErrorHandlerFunc func(event Event, err error)
 
 // ParseMetadata extracts Shopify webhook metadata from HTTP request headers.
 // Returns an error if required headers (Topic, HmacSHA256) are missing.
+//
+// An unparseable X-Shopify-Triggered-At header leaves TriggeredAt zero;
+// configure a ReplayWindow to reject such requests.
 func ParseMetadata(h http.Header) (Metadata, error) {
 	topic := h.Get("X-Shopify-Topic")
 	if topic == "" {